@@ -2,18 +2,19 @@ package main
 
 import (
 	"log"
-	"path"
 	"path/filepath"
+	"runtime"
 
-	"github.com/togostanza/ts/provider"
+	"github.com/togostanza/ts/config"
+	"github.com/togostanza/ts/stanza"
 )
 
 var cmdBuild = &Command{
 	Run:       runBuild,
 	Name:      "build",
 	Short:     "build stanza provider",
-	UsageLine: "server [-stanza-base-dir dir] [-development=false]",
-	Long:      "Build stanza provider",
+	UsageLine: "build [-stanza-base-dir dir] [-source dir]... [-depth n] [-out dir] [-base-path path] [-development=false] [-j jobs] [-inline-assets-limit bytes] [-html-import] [-hash-assets] [-gzip]",
+	Long:      "Build stanza provider. Options not given by flags are taken from ts.json in the stanza base directory.",
 }
 
 func addBuildFlags(cmd *Command) {
@@ -24,19 +25,48 @@ func addBuildFlags(cmd *Command) {
 	cmd.Flag.StringVar(&flagStanzaBaseDir, "stanza-base-dir", path, "stanza base directory")
 }
 
+func addOutputFlags(cmd *Command) {
+	cmd.Flag.StringVar(&flagOut, "out", config.DefaultOut, "output directory, relative to the stanza base directory")
+	cmd.Flag.StringVar(&flagBasePath, "base-path", config.DefaultBasePath, "URL path under which the output is served")
+}
+
+func addHtmlImportFlag(cmd *Command) {
+	cmd.Flag.BoolVar(&flagHtmlImport, "html-import", false, "also output stanzas for HTML Imports, and use them in help pages (compatibility mode)")
+}
+
 func init() {
 	addBuildFlags(cmdBuild)
+	addSourceFlags(cmdBuild)
+	addOutputFlags(cmdBuild)
 	cmdBuild.Flag.BoolVar(&flagBuildDevelopment, "development", false, "development mode")
+	addHtmlImportFlag(cmdBuild)
+	addProductionFlags(cmdBuild)
+}
+
+func addProductionFlags(cmd *Command) {
+	cmd.Flag.BoolVar(&flagBuildHashAssets, "hash-assets", false, "write assets with content-hashed names and generate manifest.json")
+	cmd.Flag.IntVar(&flagBuildJobs, "j", runtime.GOMAXPROCS(0), "number of stanzas to build in parallel")
+	cmd.Flag.BoolVar(&flagBuildGzip, "gzip", false, "write gzip-compressed variants (.gz) of text files, served by ts serve")
+	cmd.Flag.Int64Var(&flagBuildInlineAssetsLimit, "inline-assets-limit", 0, "inline assets up to this size in bytes as data URIs in production mode (0 to disable)")
 }
 
 func runBuild(cmd *Command, args []string) {
-	sp, err := provider.New(flagStanzaBaseDir)
+	sp, err := newProvider()
 	if err != nil {
 		log.Fatal(err)
 	}
-	distPath := path.Join(flagStanzaBaseDir, "dist")
-	distStanzaPath := path.Join(distPath, "stanza")
-	if err := sp.Build(distStanzaPath, flagBuildDevelopment); err != nil {
+	sp.SetJobs(flagBuildJobs)
+	distStanzaPath := conf.OutDir(flagStanzaBaseDir)
+	opts := stanza.BuildOptions{
+		Development:       flagBuildDevelopment,
+		InlineAssetsLimit: flagBuildInlineAssetsLimit,
+		HtmlImport:        flagHtmlImport,
+		HashAssets:        flagBuildHashAssets,
+		Gzip:              flagBuildGzip,
+		BaseURL:           conf.BaseURL,
+		BasePath:          conf.BasePath,
+	}
+	if err := sp.Build(distStanzaPath, opts); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/togostanza/ts/config"
+	"github.com/togostanza/ts/provider"
+)
+
+var cmdConfig = &Command{
+	Run:       runConfig,
+	Name:      "config",
+	Short:     "print effective configuration",
+	UsageLine: "config [-stanza-base-dir dir] [flags]",
+	Long:      "Print the configuration read from ts.json in the stanza base directory, merged with the defaults and the flags given. The flags of build, server and serve are accepted.",
+}
+
+// conf is the effective configuration of the command being run.
+var conf = &config.Config{}
+
+// configBindings connect flags to the fields of the configuration. Flags
+// given on the command line override the configuration, and the rest take
+// their values from it.
+var configBindings = []struct {
+	flag       string
+	fromConfig func(c *config.Config)
+	toConfig   func(c *config.Config)
+}{
+	{
+		"out",
+		func(c *config.Config) {
+			if c.Out != "" {
+				flagOut = c.Out
+			}
+		},
+		func(c *config.Config) { c.Out = flagOut },
+	},
+	{
+		"base-path",
+		func(c *config.Config) {
+			if c.BasePath != "" {
+				flagBasePath = c.BasePath
+			}
+		},
+		func(c *config.Config) { c.BasePath = flagBasePath },
+	},
+	{
+		"port",
+		func(c *config.Config) {
+			if c.Port != 0 {
+				flagPort = c.Port
+			}
+		},
+		func(c *config.Config) { c.Port = flagPort },
+	},
+	{
+		"source",
+		func(c *config.Config) {
+			if len(c.Sources) > 0 {
+				flagSources = c.Sources
+			}
+		},
+		func(c *config.Config) { c.Sources = flagSources },
+	},
+	{
+		"depth",
+		func(c *config.Config) {
+			if c.Depth != 0 {
+				flagDepth = c.Depth
+			}
+		},
+		func(c *config.Config) { c.Depth = flagDepth },
+	},
+	{
+		"cors-origin",
+		func(c *config.Config) {
+			if len(c.CORS.Origins) > 0 {
+				flagCORSOrigins = c.CORS.Origins
+			}
+		},
+		func(c *config.Config) { c.CORS.Origins = flagCORSOrigins },
+	},
+	{
+		"cors-credentials",
+		func(c *config.Config) { flagCORSCredentials = flagCORSCredentials || c.CORS.Credentials },
+		func(c *config.Config) { c.CORS.Credentials = flagCORSCredentials },
+	},
+	{
+		"cors-header",
+		func(c *config.Config) {
+			if len(c.CORS.Headers) > 0 {
+				flagCORSHeaders = c.CORS.Headers
+			}
+		},
+		func(c *config.Config) { c.CORS.Headers = flagCORSHeaders },
+	},
+	{
+		"cors-max-age",
+		func(c *config.Config) {
+			if c.CORS.MaxAge != 0 {
+				flagCORSMaxAge = c.CORS.MaxAge
+			}
+		},
+		func(c *config.Config) { c.CORS.MaxAge = flagCORSMaxAge },
+	},
+	{
+		"sparql-endpoint",
+		func(c *config.Config) {
+			if len(c.SparqlProxy.Endpoints) > 0 {
+				flagSparqlEndpoints = c.SparqlProxy.Endpoints
+			}
+		},
+		func(c *config.Config) { c.SparqlProxy.Endpoints = flagSparqlEndpoints },
+	},
+	{
+		"sparql-cache",
+		func(c *config.Config) {
+			if c.SparqlProxy.Cache != "" {
+				flagSparqlCache = c.SparqlProxy.Cache
+			}
+		},
+		func(c *config.Config) { c.SparqlProxy.Cache = flagSparqlCache },
+	},
+	{
+		"sparql-replay",
+		func(c *config.Config) { flagSparqlReplay = flagSparqlReplay || c.SparqlProxy.Replay },
+		func(c *config.Config) { c.SparqlProxy.Replay = flagSparqlReplay },
+	},
+	{
+		"html-import",
+		func(c *config.Config) { flagHtmlImport = flagHtmlImport || c.HtmlImport },
+		func(c *config.Config) { c.HtmlImport = flagHtmlImport },
+	},
+	{
+		"j",
+		func(c *config.Config) {
+			if c.Production.Jobs != 0 {
+				flagBuildJobs = c.Production.Jobs
+			}
+		},
+		func(c *config.Config) { c.Production.Jobs = flagBuildJobs },
+	},
+	{
+		"inline-assets-limit",
+		func(c *config.Config) {
+			if c.Production.InlineAssetsLimit != 0 {
+				flagBuildInlineAssetsLimit = c.Production.InlineAssetsLimit
+			}
+		},
+		func(c *config.Config) { c.Production.InlineAssetsLimit = flagBuildInlineAssetsLimit },
+	},
+	{
+		"hash-assets",
+		func(c *config.Config) { flagBuildHashAssets = flagBuildHashAssets || c.Production.HashAssets },
+		func(c *config.Config) { c.Production.HashAssets = flagBuildHashAssets },
+	},
+	{
+		"gzip",
+		func(c *config.Config) { flagBuildGzip = flagBuildGzip || c.Production.Gzip },
+		func(c *config.Config) { c.Production.Gzip = flagBuildGzip },
+	},
+}
+
+// loadConfig reads the configuration of the stanza base directory and merges
+// the flags of cmd into it.
+func loadConfig(cmd *Command) error {
+	c, err := config.Load(flagStanzaBaseDir)
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	cmd.Flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, b := range configBindings {
+		if cmd.Flag.Lookup(b.flag) == nil {
+			continue
+		}
+		if !set[b.flag] {
+			b.fromConfig(c)
+		}
+		b.toConfig(c)
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c.SetDefaults()
+
+	conf = c
+	return nil
+}
+
+// newProvider returns the provider of the stanza base directory, selecting
+// stanzas as configured.
+func newProvider() (*provider.StanzaProvider, error) {
+	sp, err := provider.New(flagStanzaBaseDir)
+	if err != nil {
+		return nil, err
+	}
+	sp.SetFilter(conf.Includes)
+	sp.SetSources(conf.SourceDirs(flagStanzaBaseDir), conf.Depth)
+	sp.SetIgnore([]string{conf.OutDir(flagStanzaBaseDir)})
+	return sp, nil
+}
+
+// stringsFlag is a flag which can be given multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func addSourceFlags(cmd *Command) {
+	cmd.Flag.Var(&flagSources, "source", "directory containing stanzas, relative to the stanza base directory (repeatable; defaults to the stanza base directory)")
+	cmd.Flag.IntVar(&flagDepth, "depth", 1, "how deep stanza directories are searched under the sources")
+}
+
+func init() {
+	addBuildFlags(cmdConfig)
+	addOutputFlags(cmdConfig)
+	addSourceFlags(cmdConfig)
+	cmdConfig.Flag.IntVar(&flagPort, "port", 8080, "port to listen on")
+	addHtmlImportFlag(cmdConfig)
+	addProductionFlags(cmdConfig)
+	addCORSFlags(cmdConfig)
+	addSparqlProxyFlags(cmdConfig)
+}
+
+func runConfig(cmd *Command, args []string) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(conf); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of the configuration file in the stanza base directory.
+const FileName = "ts.json"
+
+// Config is the provider-level configuration. Zero values mean the defaults.
+type Config struct {
+	// Out is the output directory of the stanzas, relative to the stanza
+	// base directory unless absolute.
+	Out string `json:"out,omitempty"`
+
+	// BasePath is the URL path under which the contents of Out are served.
+	BasePath string `json:"basePath,omitempty"`
+
+	// Port is the port `ts server` listens on.
+	Port int `json:"port,omitempty"`
+
+	// Sources are the directories containing stanzas, relative to the
+	// stanza base directory unless absolute. Defaults to the stanza base
+	// directory itself.
+	Sources []string `json:"sources,omitempty"`
+
+	// Depth is how deep stanza directories are searched under the sources.
+	// 1 means only the direct subdirectories.
+	Depth int `json:"depth,omitempty"`
+
+	// Include and Exclude are glob patterns (see path.Match) of the stanza
+	// directories, relative to the sources. If Include is empty, all
+	// stanzas are included.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+
+	// BaseURL is the URL where the stanzas are published, used in the
+	// snippets shown in help pages.
+	BaseURL string `json:"baseURL,omitempty"`
+
+	// HtmlImport enables the output for HTML Imports.
+	HtmlImport bool `json:"htmlImport,omitempty"`
+
+	// CORS is the policy of cross-origin requests to the stanzas.
+	CORS CORS `json:"cors"`
+
+	// SparqlProxy configures the SPARQL proxy of `ts server`.
+	SparqlProxy SparqlProxy `json:"sparqlProxy"`
+
+	// Defaults are the metadata filled into new stanzas.
+	Defaults Defaults `json:"defaults"`
+
+	// Production are the options of production builds.
+	Production Production `json:"production"`
+}
+
+// CORS is the policy of cross-origin resource sharing.
+type CORS struct {
+	// Origins are the origins allowed to load the stanzas, such as
+	// "https://example.org". "*" allows any origin.
+	Origins []string `json:"origins,omitempty"`
+
+	// Credentials allows requests with credentials such as cookies. The
+	// origin of the request is returned instead of "*" then.
+	Credentials bool `json:"credentials,omitempty"`
+
+	// Headers are the request headers allowed in addition to the
+	// CORS-safelisted ones.
+	Headers []string `json:"headers,omitempty"`
+
+	// MaxAge is how long in seconds the results of preflight requests may be
+	// cached. The browser's default is used if 0.
+	MaxAge int `json:"maxAge,omitempty"`
+}
+
+// AllowsOrigin reports whether requests from origin are allowed.
+func (c *CORS) AllowsOrigin(origin string) bool {
+	for _, o := range c.Origins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// SparqlProxy configures the proxy stanzas query SPARQL endpoints through
+// during development.
+type SparqlProxy struct {
+	// Endpoints are the endpoints queried through the proxy. The proxy is
+	// disabled if empty, unless Replay is set.
+	Endpoints []string `json:"endpoints,omitempty"`
+
+	// Cache is the directory where the responses are kept, relative to the
+	// stanza base directory unless absolute.
+	Cache string `json:"cache,omitempty"`
+
+	// Replay makes the proxy respond only from the cache.
+	Replay bool `json:"replay,omitempty"`
+}
+
+// Defaults are the default values of the metadata of new stanzas.
+type Defaults struct {
+	Author   string `json:"author,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Provider string `json:"provider,omitempty"`
+	License  string `json:"license,omitempty"`
+}
+
+// Production are the options of production builds.
+type Production struct {
+	Jobs              int   `json:"jobs,omitempty"`
+	InlineAssetsLimit int64 `json:"inlineAssetsLimit,omitempty"`
+	HashAssets        bool  `json:"hashAssets,omitempty"`
+	Gzip              bool  `json:"gzip,omitempty"`
+}
+
+// Path returns the path of the configuration file in baseDir.
+func Path(baseDir string) string {
+	return path.Join(baseDir, FileName)
+}
+
+// Load reads the configuration file in baseDir. It returns the zero Config if
+// the file does not exist.
+func Load(baseDir string) (*Config, error) {
+	var c Config
+
+	f, err := os.Open(Path(baseDir))
+	if os.IsNotExist(err) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %s", Path(baseDir), err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", Path(baseDir), err)
+	}
+	return &c, nil
+}
+
+// Validate checks the values which would be misread or would make the
+// server unsafe.
+func (c *Config) Validate() error {
+	for _, pattern := range append(append([]string{}, c.Include...), c.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+	}
+	if c.Depth < 0 {
+		return fmt.Errorf("invalid depth %d", c.Depth)
+	}
+	for _, origin := range c.CORS.Origins {
+		if origin != "*" && !originPattern.MatchString(origin) {
+			return fmt.Errorf("invalid CORS origin %q: must be \"*\" or scheme://host[:port]", origin)
+		}
+	}
+	for _, header := range c.CORS.Headers {
+		if !headerPattern.MatchString(header) {
+			return fmt.Errorf("invalid CORS header %q", header)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		return fmt.Errorf("invalid CORS max age %d", c.CORS.MaxAge)
+	}
+	for _, endpoint := range c.SparqlProxy.Endpoints {
+		// the proxy would forward queries to any host
+		if endpoint == "*" {
+			return fmt.Errorf("SPARQL proxy endpoint \"*\" is not allowed; list the endpoints")
+		}
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid SPARQL proxy endpoint %q: must be an http or https URL", endpoint)
+		}
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.Port)
+	}
+	return nil
+}
+
+var (
+	originPattern = regexp.MustCompile(`^[a-z][a-z0-9+.-]*://[^/?#\s]+$`)
+	headerPattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+)
+
+// Default values.
+const (
+	DefaultOut         = "dist/stanza"
+	DefaultBasePath    = "/stanza/"
+	DefaultSparqlCache = ".ts-cache/sparql"
+)
+
+// SetDefaults fills the unset fields with the defaults, and normalizes
+// BasePath to begin and end with a slash.
+func (c *Config) SetDefaults() {
+	if c.Out == "" {
+		c.Out = DefaultOut
+	}
+	if c.BasePath == "" {
+		c.BasePath = DefaultBasePath
+	}
+	if len(c.Sources) == 0 {
+		c.Sources = []string{"."}
+	}
+	if c.Depth < 1 {
+		c.Depth = 1
+	}
+	if c.SparqlProxy.Cache == "" {
+		c.SparqlProxy.Cache = DefaultSparqlCache
+	}
+	if len(c.CORS.Origins) == 0 {
+		c.CORS.Origins = []string{"*"}
+	}
+	c.BasePath = "/" + strings.Trim(c.BasePath, "/") + "/"
+	if c.BasePath == "//" {
+		c.BasePath = "/"
+	}
+}
+
+// OutDir returns the output directory for the stanza base directory.
+func (c *Config) OutDir(baseDir string) string {
+	out := c.Out
+	if out == "" {
+		out = DefaultOut
+	}
+	return resolve(baseDir, out)
+}
+
+// SparqlCacheDir returns the cache directory of the SPARQL proxy for the
+// stanza base directory.
+func (c *Config) SparqlCacheDir(baseDir string) string {
+	cache := c.SparqlProxy.Cache
+	if cache == "" {
+		cache = DefaultSparqlCache
+	}
+	return resolve(baseDir, cache)
+}
+
+// SourceDirs returns the source directories for the stanza base directory.
+func (c *Config) SourceDirs(baseDir string) []string {
+	if len(c.Sources) == 0 {
+		return []string{baseDir}
+	}
+	dirs := make([]string, len(c.Sources))
+	for i, source := range c.Sources {
+		dirs[i] = resolve(baseDir, source)
+	}
+	return dirs
+}
+
+func resolve(baseDir, p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return path.Join(baseDir, p)
+}
+
+// Includes reports whether the stanza in the directory dir, relative to the
+// source, is included.
+func (c *Config) Includes(dir string) bool {
+	for _, pattern := range c.Exclude {
+		if ok, _ := path.Match(pattern, dir); ok {
+			return false
+		}
+	}
+	if len(c.Include) == 0 {
+		return true
+	}
+	for _, pattern := range c.Include {
+		if ok, _ := path.Match(pattern, dir); ok {
+			return true
+		}
+	}
+	return false
+}
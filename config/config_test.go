@@ -0,0 +1,166 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "ts-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() without %s: %v", FileName, err)
+	}
+	if !reflect.DeepEqual(c, &Config{}) {
+		t.Errorf("Load() without %s = %+v, want the zero Config", FileName, c)
+	}
+
+	dir = writeConfig(t, `{
+  "out": "public",
+  "basePath": "/s",
+  "sources": ["stanzas"],
+  "cors": {"origins": ["https://example.org"], "maxAge": 60},
+  "production": {"gzip": true}
+}`)
+	defer os.RemoveAll(dir)
+	c, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Out:        "public",
+		BasePath:   "/s",
+		Sources:    []string{"stanzas"},
+		CORS:       CORS{Origins: []string{"https://example.org"}, MaxAge: 60},
+		Production: Production{Gzip: true},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Load() = %+v, want %+v", c, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{`{"outDir": "public"}`, `unknown field "outDir"`},
+		{`{"out": 1}`, "cannot unmarshal number"},
+		{`{"include": ["[a"]}`, `invalid pattern "[a"`},
+		{`{"depth": -1}`, "invalid depth -1"},
+		{`{"cors": {"origins": ["example.org"]}}`, `invalid CORS origin "example.org"`},
+		{`{"cors": {"origins": ["https://example.org/"]}}`, `invalid CORS origin "https://example.org/"`},
+		{`{"cors": {"headers": ["X Token"]}}`, `invalid CORS header "X Token"`},
+		{`{"cors": {"maxAge": -1}}`, "invalid CORS max age -1"},
+		{`{"port": 65536}`, "invalid port 65536"},
+		{`{"sparqlProxy": {"endpoints": ["*"]}}`, `SPARQL proxy endpoint "*" is not allowed`},
+		{`{"sparqlProxy": {"endpoints": ["example.org/sparql"]}}`, `invalid SPARQL proxy endpoint "example.org/sparql"`},
+	}
+
+	for _, tt := range tests {
+		dir := writeConfig(t, tt.content)
+		_, err := Load(dir)
+		os.RemoveAll(dir)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Load() of %s = %v, want an error containing %q", tt.content, err, tt.err)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), Path(dir)+": ") {
+			t.Errorf("Load() of %s = %v, want the error prefixed with the path", tt.content, err)
+		}
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	var c Config
+	c.SetDefaults()
+	want := Config{
+		Out:         DefaultOut,
+		BasePath:    DefaultBasePath,
+		Sources:     []string{"."},
+		Depth:       1,
+		CORS:        CORS{Origins: []string{"*"}},
+		SparqlProxy: SparqlProxy{Cache: DefaultSparqlCache},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("SetDefaults() = %+v, want %+v", c, want)
+	}
+
+	for basePath, want := range map[string]string{
+		"/":        "/",
+		"stanza":   "/stanza/",
+		"/stanza":  "/stanza/",
+		"stanza/":  "/stanza/",
+		"/a/b//":   "/a/b/",
+		"/stanza/": "/stanza/",
+	} {
+		c := Config{BasePath: basePath}
+		c.SetDefaults()
+		if c.BasePath != want {
+			t.Errorf("SetDefaults() with BasePath %q: BasePath = %q, want %q", basePath, c.BasePath, want)
+		}
+	}
+}
+
+func TestDirs(t *testing.T) {
+	c := Config{Out: "public", Sources: []string{"stanzas", "/abs"}, SparqlProxy: SparqlProxy{Cache: "/tmp/cache"}}
+	if got := c.OutDir("/base"); got != "/base/public" {
+		t.Errorf("OutDir() = %q", got)
+	}
+	if got := c.SparqlCacheDir("/base"); got != "/tmp/cache" {
+		t.Errorf("SparqlCacheDir() = %q", got)
+	}
+	if got, want := c.SourceDirs("/base"), []string{"/base/stanzas", "/abs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SourceDirs() = %q, want %q", got, want)
+	}
+
+	var zero Config
+	if got := zero.OutDir("/base"); got != "/base/"+DefaultOut {
+		t.Errorf("OutDir() of the zero Config = %q", got)
+	}
+	if got, want := zero.SourceDirs("/base"), []string{"/base"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SourceDirs() of the zero Config = %q, want %q", got, want)
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	tests := []struct {
+		include, exclude []string
+		dir              string
+		want             bool
+	}{
+		{nil, nil, "hello", true},
+		{[]string{"he*"}, nil, "hello", true},
+		{[]string{"he*"}, nil, "world", false},
+		{nil, []string{"wip-*"}, "wip-table", false},
+		{[]string{"*"}, []string{"wip-*"}, "wip-table", false},
+		{[]string{"group/*"}, nil, "group/hello", true},
+		{[]string{"group/*"}, nil, "hello", false},
+	}
+
+	for _, tt := range tests {
+		c := Config{Include: tt.include, Exclude: tt.exclude}
+		if got := c.Includes(tt.dir); got != tt.want {
+			t.Errorf("include %q, exclude %q: Includes(%q) = %v, want %v", tt.include, tt.exclude, tt.dir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/togostanza/ts/config"
+)
+
+// newConfigTestCommand returns a command with the flags bound to the
+// configuration, reset to their defaults.
+func newConfigTestCommand() *Command {
+	flagSources, flagCORSOrigins, flagCORSHeaders, flagSparqlEndpoints = nil, nil, nil, nil
+
+	cmd := &Command{Name: "test"}
+	cmd.Flag.IntVar(&flagPort, "port", 8080, "")
+	addBuildFlags(cmd)
+	addOutputFlags(cmd)
+	addSourceFlags(cmd)
+	addHtmlImportFlag(cmd)
+	addProductionFlags(cmd)
+	addCORSFlags(cmd)
+	addSparqlProxyFlags(cmd)
+	return cmd
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, config.FileName), []byte(`{
+  "out": "public",
+  "basePath": "s",
+  "port": 3000,
+  "sources": ["stanzas"],
+  "cors": {"origins": ["https://example.org"], "credentials": true},
+  "production": {"hashAssets": true, "jobs": 2}
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(c *config.Config) { conf = c }(conf)
+
+	tests := []struct {
+		name string
+		args []string
+		want func(c *config.Config)
+	}{
+		{
+			name: "configuration only",
+			want: func(c *config.Config) {},
+		},
+		{
+			name: "flags override",
+			args: []string{"-out", "build", "-base-path", "/x/", "-port", "8080", "-source", "a", "-source", "b", "-cors-origin", "*", "-j", "4"},
+			want: func(c *config.Config) {
+				c.Out = "build"
+				c.BasePath = "/x/"
+				c.Port = 8080
+				c.Sources = []string{"a", "b"}
+				c.CORS.Origins = []string{"*"}
+				c.Production.Jobs = 4
+			},
+		},
+		{
+			name: "boolean flags override",
+			args: []string{"-cors-credentials=false", "-hash-assets=false", "-gzip"},
+			want: func(c *config.Config) {
+				c.CORS.Credentials = false
+				c.Production.HashAssets = false
+				c.Production.Gzip = true
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := newConfigTestCommand()
+			if err := cmd.Flag.Parse(append([]string{"-stanza-base-dir", dir}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadConfig(cmd); err != nil {
+				t.Fatal(err)
+			}
+
+			want := &config.Config{
+				Out:         "public",
+				BasePath:    "/s/",
+				Port:        3000,
+				Sources:     []string{"stanzas"},
+				Depth:       1,
+				CORS:        config.CORS{Origins: []string{"https://example.org"}, Credentials: true},
+				SparqlProxy: config.SparqlProxy{Cache: config.DefaultSparqlCache},
+				Production:  config.Production{HashAssets: true, Jobs: 2},
+			}
+			tt.want(want)
+			if !reflect.DeepEqual(conf, want) {
+				t.Errorf("conf = %+v\nwant %+v", conf, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigWithoutFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, config.FileName), []byte(`{"out": "public", "port": 3000}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(c *config.Config) { conf = c }(conf)
+
+	// the fields whose flags the command does not have are taken from ts.json
+	cmd := &Command{Name: "test"}
+	addBuildFlags(cmd)
+	if err := cmd.Flag.Parse([]string{"-stanza-base-dir", dir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Out != "public" || conf.Port != 3000 {
+		t.Errorf("conf = %+v, want out and port of ts.json", conf)
+	}
+}
+
+func TestLoadConfigValidatesFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(c *config.Config) { conf = c }(conf)
+
+	for _, args := range [][]string{
+		{"-sparql-endpoint", "*"},
+		{"-cors-origin", "example.org"},
+	} {
+		cmd := newConfigTestCommand()
+		if err := cmd.Flag.Parse(append([]string{"-stanza-base-dir", dir}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadConfig(cmd); err == nil {
+			t.Errorf("loadConfig() with %q: no error", args)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/togostanza/ts/config"
+)
+
+var (
+	flagCORSOrigins     stringsFlag
+	flagCORSCredentials bool
+	flagCORSHeaders     stringsFlag
+	flagCORSMaxAge      int
+)
+
+func addCORSFlags(cmd *Command) {
+	cmd.Flag.Var(&flagCORSOrigins, "cors-origin", "origin allowed to load the stanzas, or * for any origin (repeatable; defaults to *)")
+	cmd.Flag.BoolVar(&flagCORSCredentials, "cors-credentials", false, "allow cross-origin requests with credentials")
+	cmd.Flag.Var(&flagCORSHeaders, "cors-header", "request header allowed in cross-origin requests (repeatable)")
+	cmd.Flag.IntVar(&flagCORSMaxAge, "cors-max-age", 0, "seconds for which the results of preflight requests may be cached (0 for the browser's default)")
+}
+
+// corsMethods are the methods allowed in cross-origin requests.
+const corsMethods = "GET, HEAD, POST"
+
+// withCORS adds the headers of CORS to the responses of h according to policy,
+// and responds to preflight requests.
+func withCORS(policy config.CORS, h http.Handler) http.Handler {
+	anyOrigin := false
+	for _, o := range policy.Origins {
+		if o == "*" {
+			anyOrigin = true
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := w.Header()
+		origin := req.Header.Get("Origin")
+		preflight := req.Method == http.MethodOptions && origin != "" && req.Header.Get("Access-Control-Request-Method") != ""
+
+		// the response depends on the origin unless any origin gets "*"
+		if !anyOrigin || policy.Credentials {
+			header.Add("Vary", "Origin")
+		}
+		allowed := origin != "" && policy.AllowsOrigin(origin)
+		if anyOrigin && !policy.Credentials {
+			// regardless of Origin, so that cached responses work for any origin
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else if allowed {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if allowed && policy.Credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		if !allowed {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		header.Set("Access-Control-Allow-Methods", corsMethods)
+		if len(policy.Headers) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+		}
+		if policy.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
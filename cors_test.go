@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/togostanza/ts/config"
+)
+
+func TestWithCORS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		name        string
+		policy      config.CORS
+		method      string
+		header      map[string]string
+		status      int
+		allowOrigin string
+		credentials string
+		methods     string
+		headers     string
+		maxAge      string
+		vary        []string
+	}{
+		{
+			name:        "any origin without Origin",
+			policy:      config.CORS{Origins: []string{"*"}},
+			method:      "GET",
+			status:      200,
+			allowOrigin: "*",
+		},
+		{
+			name:        "any origin",
+			policy:      config.CORS{Origins: []string{"*"}},
+			method:      "GET",
+			header:      map[string]string{"Origin": "https://a.example"},
+			status:      200,
+			allowOrigin: "*",
+		},
+		{
+			name:        "listed origin",
+			policy:      config.CORS{Origins: []string{"https://a.example"}},
+			method:      "GET",
+			header:      map[string]string{"Origin": "https://a.example"},
+			status:      200,
+			allowOrigin: "https://a.example",
+			vary:        []string{"Origin"},
+		},
+		{
+			name:   "unlisted origin",
+			policy: config.CORS{Origins: []string{"https://a.example"}},
+			method: "GET",
+			header: map[string]string{"Origin": "https://b.example"},
+			status: 200,
+			vary:   []string{"Origin"},
+		},
+		{
+			name:        "any origin with credentials",
+			policy:      config.CORS{Origins: []string{"*"}, Credentials: true},
+			method:      "GET",
+			header:      map[string]string{"Origin": "https://a.example"},
+			status:      200,
+			allowOrigin: "https://a.example",
+			credentials: "true",
+			vary:        []string{"Origin"},
+		},
+		{
+			name:   "unlisted origin with credentials",
+			policy: config.CORS{Origins: []string{"https://a.example"}, Credentials: true},
+			method: "GET",
+			header: map[string]string{"Origin": "https://b.example"},
+			status: 200,
+			vary:   []string{"Origin"},
+		},
+		{
+			name:   "preflight",
+			policy: config.CORS{Origins: []string{"https://a.example"}, Headers: []string{"X-Token", "X-Trace"}, MaxAge: 600},
+			method: "OPTIONS",
+			header: map[string]string{
+				"Origin":                         "https://a.example",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "x-token",
+			},
+			status:      204,
+			allowOrigin: "https://a.example",
+			methods:     corsMethods,
+			headers:     "X-Token, X-Trace",
+			maxAge:      "600",
+			vary:        []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		},
+		{
+			name:   "preflight of unlisted origin",
+			policy: config.CORS{Origins: []string{"https://a.example"}},
+			method: "OPTIONS",
+			header: map[string]string{
+				"Origin":                        "https://b.example",
+				"Access-Control-Request-Method": "POST",
+			},
+			status: 403,
+			vary:   []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		},
+		{
+			name:   "OPTIONS without Access-Control-Request-Method",
+			policy: config.CORS{Origins: []string{"*"}},
+			method: "OPTIONS",
+			header: map[string]string{"Origin": "https://a.example"},
+			// not a preflight; passed to the handler
+			status:      200,
+			allowOrigin: "*",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/stanza/", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			withCORS(tt.policy, ok).ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			h := w.Header()
+			for _, c := range []struct{ name, got, want string }{
+				{"Access-Control-Allow-Origin", h.Get("Access-Control-Allow-Origin"), tt.allowOrigin},
+				{"Access-Control-Allow-Credentials", h.Get("Access-Control-Allow-Credentials"), tt.credentials},
+				{"Access-Control-Allow-Methods", h.Get("Access-Control-Allow-Methods"), tt.methods},
+				{"Access-Control-Allow-Headers", h.Get("Access-Control-Allow-Headers"), tt.headers},
+				{"Access-Control-Max-Age", h.Get("Access-Control-Max-Age"), tt.maxAge},
+			} {
+				if c.got != c.want {
+					t.Errorf("%s = %q, want %q", c.name, c.got, c.want)
+				}
+			}
+			if vary := h["Vary"]; !reflect.DeepEqual(vary, tt.vary) && !(len(vary) == 0 && len(tt.vary) == 0) {
+				t.Errorf("Vary = %q, want %q", vary, tt.vary)
+			}
+		})
+	}
+}
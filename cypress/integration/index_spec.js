@@ -1,7 +1,7 @@
 describe("hello", () => {
   it("Navigate to Hello Example stanza", () => {
     cy.visit("/");
-    cy.contains("hello").click();
+    cy.get("a[href='hello/help.html']").click();
     cy.wait(50); // wait for the debunced update() function to be called
 
     cy.get("togostanza-hello").then($root => {
@@ -14,7 +14,7 @@ describe("hello", () => {
 describe("grouping", () => {
   it("processes gropuing()", () => {
     cy.visit("/");
-    cy.contains("grouping").click();
+    cy.get("a[href='grouping/help.html']").click();
     cy.wait(100); // wait for the debunced update() function to be called
 
     cy.get("togostanza-grouping").then($root => {
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+var cmdFixUsage = &Command{
+	Run:       runFixUsage,
+	Name:      "fix-usage",
+	Short:     "rewrite stanza usage from parameters",
+	UsageLine: "fix-usage [-stanza-base-dir dir] [-source dir]... [-depth n] [name...]",
+	Long:      "Fix stanza:usage in metadata.json of stanzas whose usage is empty or does not match stanza:parameter. Empty usage is generated from the parameter examples; otherwise undeclared attributes are removed and missing required parameters are added, keeping the rest of the usage.",
+}
+
+func init() {
+	addBuildFlags(cmdFixUsage)
+	addSourceFlags(cmdFixUsage)
+}
+
+func runFixUsage(cmd *Command, args []string) {
+	sp, err := newProvider()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fixed, err := sp.FixUsage(args)
+	for _, path := range fixed {
+		fmt.Printf("fixed %s\n", path)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
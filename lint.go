@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/togostanza/ts/stanza"
+)
+
+var cmdLint = &Command{
+	Run:       runLint,
+	Name:      "lint",
+	Short:     "check stanza metadata",
+	UsageLine: "lint [-stanza-base-dir dir] [-source dir]... [-depth n] [-json]",
+	Long:      "Check metadata.json of stanzas and report problems. Exits with non-zero status if any error is found.",
+}
+
+var flagLintJson bool
+
+func init() {
+	addBuildFlags(cmdLint)
+	addSourceFlags(cmdLint)
+	cmdLint.Flag.BoolVar(&flagLintJson, "json", false, "output diagnostics as JSON")
+}
+
+func runLint(cmd *Command, args []string) {
+	sp, err := newProvider()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	diagnostics, err := sp.Lint()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if flagLintJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(diagnostics); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, d := range diagnostics {
+			fmt.Println(d)
+		}
+	}
+
+	if stanza.HasErrors(diagnostics) {
+		os.Exit(1)
+	}
+}
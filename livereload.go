@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/togostanza/ts/provider"
+)
+
+// buildEvents delivers build results to the pages subscribing via server-sent events.
+type buildEvents struct {
+	mu      sync.Mutex
+	clients map[chan string]struct{}
+}
+
+// buildResult is the result of a build for a stanza, or an error of the
+// build not specific to a stanza if Stanza is empty.
+type buildResult struct {
+	Stanza string `json:"stanza,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// buildResults returns the results of the last build of sp for the stanzas
+// rebuilt or broken, which returned err.
+func buildResults(sp *provider.StanzaProvider, err error) []buildResult {
+	results := []buildResult{}
+	rebuilt := make(map[string]bool)
+	for _, name := range sp.Rebuilt() {
+		rebuilt[name] = true
+		if be := sp.BuildError(name); be != nil {
+			results = append(results, buildResult{Stanza: name, Status: "error", Error: be.Error()})
+		} else {
+			results = append(results, buildResult{Stanza: name, Status: "ok"})
+		}
+	}
+	// stanzas which failed to load
+	for _, be := range sp.BuildErrors() {
+		if !rebuilt[be.Stanza] {
+			results = append(results, buildResult{Stanza: be.Stanza, Status: "error", Error: be.Error()})
+		}
+	}
+	if err != nil && !isStanzaError(err) {
+		results = append(results, buildResult{Status: "error", Error: err.Error()})
+	}
+	return results
+}
+
+func newBuildEvents() *buildEvents {
+	return &buildEvents{
+		clients: make(map[chan string]struct{}),
+	}
+}
+
+func (be *buildEvents) publish(results []buildResult) {
+	data, _ := json.Marshal(results)
+
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	for ch := range be.clients {
+		select {
+		case ch <- string(data):
+		default: // slow client; drop the event
+		}
+	}
+}
+
+func (be *buildEvents) subscribe() chan string {
+	ch := make(chan string, 1)
+	be.mu.Lock()
+	be.clients[ch] = struct{}{}
+	be.mu.Unlock()
+	return ch
+}
+
+func (be *buildEvents) unsubscribe(ch chan string) {
+	be.mu.Lock()
+	delete(be.clients, ch)
+	be.mu.Unlock()
+}
+
+func (be *buildEvents) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ch := be.subscribe()
+	defer be.unsubscribe(ch)
+
+	for {
+		select {
+		case data := <-ch:
+			fmt.Fprintf(w, "event: build\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
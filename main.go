@@ -12,7 +12,16 @@ import (
 var VERSION = "snapshot"
 var flagPort int
 var flagStanzaBaseDir string
+var flagOut string
+var flagBasePath string
+var flagSources stringsFlag
+var flagDepth int
 var flagBuildDevelopment bool
+var flagBuildJobs int
+var flagBuildInlineAssetsLimit int64
+var flagHtmlImport bool
+var flagBuildHashAssets bool
+var flagBuildGzip bool
 
 type Command struct {
 	Run       func(cmd *Command, args []string)
@@ -32,7 +41,12 @@ func (c *Command) Usage() {
 var commands = []*Command{
 	cmdBuild,
 	cmdServer,
+	cmdServe,
 	cmdNew,
+	cmdRecord,
+	cmdLint,
+	cmdFixUsage,
+	cmdConfig,
 	cmdVersion,
 }
 
@@ -92,6 +106,12 @@ func main() {
 			cmd.Flag.Usage = func() { cmd.Usage() }
 			cmd.Flag.Parse(args[1:])
 			args = cmd.Flag.Args()
+			if cmd.Flag.Lookup("stanza-base-dir") != nil {
+				if err := loadConfig(cmd); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
 			cmd.Run(cmd, args)
 			os.Exit(0)
 		}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/togostanza/ts/new"
 )
@@ -10,23 +15,163 @@ import (
 var cmdNew = &Command{
 	Name:      "new",
 	Short:     "create a new stanza",
-	UsageLine: "new [-stanza-base-dir dir] [stanza name]",
-	Long:      "Create a new stanza",
+	UsageLine: "new [-stanza-base-dir dir] [-blueprint name] [-blueprint-dir dir] [-force] [-dry-run] [-i] [-label label] [-definition text] [-author name] [-license license] [-param key:description:example]... [stanza name]",
+	Long:      "Create a new stanza from a blueprint. Blueprints are looked up in -blueprint-dir, the blueprints directory in the stanza base directory and the built-in ones in this order. Use -list to show the available blueprints. The name of the stanza may only contain lowercase alphanumerics and hyphens, and must start with a letter. Metadata not given by flags are taken from the defaults in ts.json in the stanza base directory.",
 }
 
+// parameterFlags collects -param flags.
+type parameterFlags []new.Parameter
+
+func (f *parameterFlags) String() string {
+	keys := []string{}
+	for _, p := range *f {
+		keys = append(keys, p.Key)
+	}
+	return strings.Join(keys, ",")
+}
+
+func (f *parameterFlags) Set(s string) error {
+	p, err := new.ParseParameter(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, p)
+	return nil
+}
+
+var (
+	flagNewBlueprint    string
+	flagNewBlueprintDir string
+	flagNewList         bool
+	flagNewForce        bool
+	flagNewDryRun       bool
+	flagNewInteractive  bool
+	flagNewLabel        string
+	flagNewDefinition   string
+	flagNewAuthor       string
+	flagNewLicense      string
+	flagNewParams       parameterFlags
+)
+
 func init() {
 	cmdNew.Run = runNew // break init loop
 	addBuildFlags(cmdNew)
+	cmdNew.Flag.StringVar(&flagNewBlueprint, "blueprint", new.DefaultBlueprint, "name of the blueprint")
+	cmdNew.Flag.StringVar(&flagNewBlueprintDir, "blueprint-dir", "", "directory containing user-defined blueprints")
+	cmdNew.Flag.BoolVar(&flagNewList, "list", false, "list available blueprints")
+	cmdNew.Flag.BoolVar(&flagNewForce, "force", false, "overwrite files of an existing stanza")
+	cmdNew.Flag.BoolVar(&flagNewDryRun, "dry-run", false, "show the files to be written without writing them")
+	cmdNew.Flag.BoolVar(&flagNewInteractive, "i", false, "ask the metadata interactively")
+	cmdNew.Flag.StringVar(&flagNewLabel, "label", "", "human readable name of the stanza")
+	cmdNew.Flag.StringVar(&flagNewDefinition, "definition", "", "description of what the stanza does")
+	cmdNew.Flag.StringVar(&flagNewAuthor, "author", "", "author of the stanza")
+	cmdNew.Flag.StringVar(&flagNewLicense, "license", "", "license of the stanza")
+	cmdNew.Flag.Var(&flagNewParams, "param", "parameter of the stanza as key:description:example (repeatable)")
+}
+
+func blueprintDirs() []string {
+	dirs := []string{}
+	if flagNewBlueprintDir != "" {
+		dirs = append(dirs, flagNewBlueprintDir)
+	}
+	return append(dirs, new.BlueprintDir(flagStanzaBaseDir))
+}
+
+// prompter asks values on the terminal.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return new.OrDefault(strings.TrimSpace(line), def), nil
+}
+
+// askMetadata fills the metadata in opts interactively.
+func askMetadata(opts *new.Options) error {
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+
+	var err error
+	fields := []struct {
+		question string
+		value    *string
+	}{
+		{"Label", &opts.Label},
+		{"Definition", &opts.Definition},
+		{"Author", &opts.Author},
+		{"License", &opts.License},
+	}
+	for _, f := range fields {
+		if *f.value, err = p.ask(f.question, *f.value); err != nil {
+			return err
+		}
+	}
+
+	for {
+		s, err := p.ask("Parameter (key:description:example, empty to finish)", "")
+		if err != nil {
+			return err
+		}
+		if s == "" {
+			return nil
+		}
+		param, err := new.ParseParameter(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		opts.Parameters = append(opts.Parameters, param)
+	}
 }
 
 func runNew(cmd *Command, args []string) {
+	if flagNewList {
+		blueprints, err := new.Blueprints(blueprintDirs())
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		for _, bp := range blueprints {
+			fmt.Fprintf(w, "%s\t%s\n", bp.Name, bp.Description)
+		}
+		w.Flush()
+		return
+	}
+
 	if len(args) != 1 {
 		cmdNew.Flag.Usage()
 		os.Exit(2)
 	}
 
 	stanzaName := args[0]
-	err := new.Generate(stanzaName, flagStanzaBaseDir)
+	opts := new.Options{
+		Blueprint:     flagNewBlueprint,
+		BlueprintDirs: blueprintDirs(),
+		Force:         flagNewForce,
+		DryRun:        flagNewDryRun,
+		Label:         flagNewLabel,
+		Definition:    flagNewDefinition,
+		Author:        new.OrDefault(flagNewAuthor, conf.Defaults.Author),
+		Address:       conf.Defaults.Address,
+		Provider:      conf.Defaults.Provider,
+		License:       new.OrDefault(flagNewLicense, conf.Defaults.License),
+		Parameters:    flagNewParams,
+	}
+	if flagNewInteractive {
+		if err := askMetadata(&opts); err != nil {
+			log.Fatal(err)
+		}
+	}
+	err := new.Generate(stanzaName, flagStanzaBaseDir, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
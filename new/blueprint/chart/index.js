@@ -0,0 +1,49 @@
+Stanza(function(stanza, params) {
+  stanza.query({
+    endpoint: params.endpoint,
+    template: "stanza.rq",
+    parameters: params
+  }).then(function(data) {
+    const rows = stanza.unwrapValueFromBinding(data).map(function(row) {
+      return {label: row.label, value: Number(row.value)};
+    });
+
+    stanza.render({
+      template: "stanza.html",
+      parameters: {}
+    });
+
+    const width = 600;
+    const height = 300;
+    const margin = {top: 10, right: 10, bottom: 30, left: 40};
+
+    const x = d3.scaleBand()
+      .domain(rows.map(function(d) { return d.label; }))
+      .range([margin.left, width - margin.right])
+      .padding(0.1);
+    const y = d3.scaleLinear()
+      .domain([0, d3.max(rows, function(d) { return d.value; })]).nice()
+      .range([height - margin.bottom, margin.top]);
+
+    const svg = d3.select(stanza.select("svg"))
+      .attr("width", width)
+      .attr("height", height);
+
+    svg.append("g")
+      .selectAll("rect")
+      .data(rows)
+      .enter().append("rect")
+        .attr("x", function(d) { return x(d.label); })
+        .attr("y", function(d) { return y(d.value); })
+        .attr("width", x.bandwidth())
+        .attr("height", function(d) { return y(0) - y(d.value); });
+
+    svg.append("g")
+      .attr("transform", "translate(0," + (height - margin.bottom) + ")")
+      .call(d3.axisBottom(x));
+
+    svg.append("g")
+      .attr("transform", "translate(" + margin.left + ",0)")
+      .call(d3.axisLeft(y));
+  });
+});
@@ -0,0 +1,15 @@
+Stanza(function(stanza, params) {
+  stanza.query({
+    endpoint: params.endpoint,
+    template: "stanza.rq",
+    parameters: params
+  }).then(function(data) {
+    stanza.render({
+      template: "stanza.html",
+      parameters: {
+        resource: params.resource,
+        properties: stanza.unwrapValueFromBinding(data)
+      }
+    });
+  });
+});
@@ -0,0 +1,21 @@
+Stanza(function(stanza, params) {
+  stanza.query({
+    endpoint: params.endpoint,
+    template: "stanza.rq",
+    parameters: params
+  }).then(function(data) {
+    const bindings = data.results.bindings;
+
+    stanza.render({
+      template: "stanza.html",
+      parameters: {
+        columns: data.head.vars,
+        rows: bindings.map(function(binding) {
+          return data.head.vars.map(function(v) {
+            return binding[v] ? binding[v].value : "";
+          });
+        })
+      }
+    });
+  });
+});
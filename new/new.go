@@ -1,10 +1,16 @@
 package new
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
@@ -12,75 +18,360 @@ import (
 
 //go:generate go-bindata -pkg new blueprint/...
 
+// DefaultBlueprint is the name of the blueprint used if none is specified.
+const DefaultBlueprint = "hello"
+
+// builtinDescriptions describes the blueprints under the blueprint directory.
+var builtinDescriptions = map[string]string{
+	"hello":   "Hello World",
+	"table":   "table of the results of a SPARQL query",
+	"chart":   "bar chart of the results of a SPARQL query with D3.js",
+	"summary": "key/value summary of a resource",
+}
+
+// Parameter is a parameter of the stanza to be generated.
+type Parameter struct {
+	Key         string
+	Description string
+	Example     string
+}
+
+// ParseParameter parses a parameter given as "key:description:example".
+// The description and the example may be omitted.
+func ParseParameter(s string) (Parameter, error) {
+	fields := strings.SplitN(s, ":", 3)
+	for len(fields) < 3 {
+		fields = append(fields, "")
+	}
+	if !parameterKeyPattern.MatchString(fields[0]) {
+		return Parameter{}, fmt.Errorf("invalid parameter %q: key must consist of lowercase alphanumerics, hyphens and underscores, and start with a letter", s)
+	}
+	return Parameter{Key: fields[0], Description: fields[1], Example: fields[2]}, nil
+}
+
+// parameters are the values substituted in the blueprint templates.
 type parameters struct {
-	Name    string
-	Created string
-	Updated string
+	Name       string
+	Created    string
+	Updated    string
+	Label      string
+	Definition string
+	Author     string
+	Address    string
+	Provider   string
+	License    string
+	Parameters []Parameter
+}
+
+// UsageAttributes returns the attributes of the parameters to be put in
+// stanza:usage, escaped for a JSON string.
+func (p *parameters) UsageAttributes() (string, error) {
+	var b strings.Builder
+	for _, param := range p.Parameters {
+		fmt.Fprintf(&b, " %s=\"%s\"", param.Key, attributeEscaper.Replace(param.Example))
+	}
+
+	quoted, err := marshalJSON(b.String())
+	if err != nil {
+		return "", err
+	}
+	return quoted[1 : len(quoted)-1], nil
 }
 
-func extractBlueprintAsset(dir, name string, params *parameters) error {
-	t := MustTemplateAsset(name)
+var templateFuncs = template.FuncMap{
+	"json": marshalJSON,
+}
 
-	s := strings.SplitN(name, "/", 2)
-	if len(s) != 2 {
-		return fmt.Errorf("unexpected name: %s", name)
+// marshalJSON returns v as JSON, leaving <, > and & as they are for readability.
+func marshalJSON(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return "", err
 	}
-	destName := s[1]
-	err := os.MkdirAll(_filePath(dir, path.Dir(destName)), os.FileMode(0755))
+	return strings.TrimSpace(buf.String()), nil
+}
+
+var attributeEscaper = strings.NewReplacer("&", "&amp;", "\"", "&quot;", "<", "&lt;", ">", "&gt;")
+
+// Options controls how a stanza is generated.
+type Options struct {
+	// Blueprint is the name of the blueprint. DefaultBlueprint is used if empty.
+	Blueprint string
+
+	// BlueprintDirs are directories containing user-defined blueprints, each
+	// of which is a subdirectory. They take precedence over the built-in
+	// blueprints in the order given.
+	BlueprintDirs []string
+
+	// Force allows writing into an existing stanza directory.
+	Force bool
+
+	// DryRun only logs the files which would be written.
+	DryRun bool
+
+	// Metadata of the stanza. The blueprint's defaults are used for empty
+	// Label and Definition, and placeholders for the rest.
+	Label      string
+	Definition string
+	Author     string
+	Address    string
+	Provider   string
+	License    string
+	Parameters []Parameter
+}
+
+var (
+	namePattern         = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+	parameterKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+)
+
+// reservedNames are directories in the stanza base directory used by ts itself.
+var reservedNames = map[string]bool{
+	"dist":       true,
+	"blueprints": true,
+}
+
+// ValidateName checks that name can be used for a stanza. The element name of
+// the stanza, "togostanza-<name>", must be a valid custom element name, so
+// only lowercase alphanumerics and hyphens are allowed and name must start
+// with a letter.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid stanza name %q: only lowercase alphanumerics and hyphens are allowed, and the first character must be a letter", name)
+	}
+	if reservedNames[name] {
+		return fmt.Errorf("invalid stanza name %q: the name is reserved", name)
+	}
+	return nil
+}
+
+// Blueprint is a template of a stanza.
+type Blueprint struct {
+	Name        string
+	Description string
+
+	// Dir is the directory of a user-defined blueprint, or empty for a
+	// built-in one.
+	Dir string
+}
+
+// Blueprints returns the available blueprints sorted by name. A blueprint in
+// dirs hides the one with the same name in the later dirs or built-in.
+func Blueprints(dirs []string) ([]*Blueprint, error) {
+	found := make(map[string]*Blueprint)
+
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if !info.IsDir() || found[info.Name()] != nil {
+				continue
+			}
+			bpDir := filepath.Join(dir, info.Name())
+			found[info.Name()] = &Blueprint{
+				Name:        info.Name(),
+				Description: bpDir,
+				Dir:         bpDir,
+			}
+		}
+	}
+
+	names, err := AssetDir("blueprint")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, name := range names {
+		if found[name] != nil {
+			continue
+		}
+		found[name] = &Blueprint{
+			Name:        name,
+			Description: builtinDescriptions[name],
+		}
+	}
+
+	blueprints := make([]*Blueprint, 0, len(found))
+	for _, bp := range found {
+		blueprints = append(blueprints, bp)
 	}
+	sort.Slice(blueprints, func(i, j int) bool {
+		return blueprints[i].Name < blueprints[j].Name
+	})
+	return blueprints, nil
+}
+
+// FindBlueprint returns the blueprint of the name.
+func FindBlueprint(name string, dirs []string) (*Blueprint, error) {
+	blueprints, err := Blueprints(dirs)
+	if err != nil {
+		return nil, err
+	}
+	for _, bp := range blueprints {
+		if bp.Name == name {
+			return bp, nil
+		}
+	}
+	return nil, fmt.Errorf("blueprint %q is not found (see `ts new -list`)", name)
+}
+
+// files returns the paths of the files in the blueprint, relative to it.
+func (bp *Blueprint) files() ([]string, error) {
+	if bp.Dir == "" {
+		prefix := path.Join("blueprint", bp.Name) + "/"
+		files := []string{}
+		for _, name := range AssetNames() {
+			if strings.HasPrefix(name, prefix) {
+				files = append(files, strings.TrimPrefix(name, prefix))
+			}
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	files := []string{}
+	err := filepath.Walk(bp.Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(bp.Dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
 
-	destPath := _filePath(dir, destName)
-	w, err := os.Create(destPath)
+func (bp *Blueprint) readFile(name string) ([]byte, error) {
+	if bp.Dir == "" {
+		return Asset(path.Join("blueprint", bp.Name, name))
+	}
+	return ioutil.ReadFile(filepath.Join(bp.Dir, filepath.FromSlash(name)))
+}
+
+// isTemplate reports whether the file is processed with text/template.
+// Assets such as images are copied as is.
+func isTemplate(name string) bool {
+	return !strings.HasPrefix(name, "assets/")
+}
+
+func (bp *Blueprint) extractFile(dir, name string, params *parameters, dryRun bool) error {
+	data, err := bp.readFile(name)
 	if err != nil {
 		return err
 	}
-	defer w.Close()
 
-	err = t.Execute(w, params)
+	if isTemplate(name) {
+		t, err := template.New(name).Funcs(templateFuncs).Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("blueprint %s: %s", bp.Name, err)
+		}
+		var b strings.Builder
+		if err := t.Execute(&b, params); err != nil {
+			return fmt.Errorf("blueprint %s: %s", bp.Name, err)
+		}
+		data = []byte(b.String())
+	}
+
+	destPath := _filePath(dir, name)
+	if dryRun {
+		if _, err := os.Stat(destPath); err == nil {
+			log.Printf("would overwrite %s", destPath)
+		} else {
+			log.Printf("would write %s", destPath)
+		}
+		return nil
+	}
+
+	err = os.MkdirAll(_filePath(dir, path.Dir(name)), os.FileMode(0755))
 	if err != nil {
 		return err
 	}
 
+	if err := ioutil.WriteFile(destPath, data, os.FileMode(0644)); err != nil {
+		return err
+	}
+
 	log.Printf("wrote %s", destPath)
 
 	return nil
 }
 
-func extractBlueprintAssets(dir, name string, params *parameters) error {
-	children, err := AssetDir(name)
-	if err != nil { // File
-		return extractBlueprintAsset(dir, name, params)
-	} else { // Dir
-		for _, child := range children {
-			err = extractBlueprintAssets(dir, path.Join(name, child), params)
-			if err != nil {
-				return err
-			}
+func (bp *Blueprint) extract(dir string, params *parameters, dryRun bool) error {
+	files, err := bp.files()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("blueprint %s has no files", bp.Name)
+	}
+	for _, name := range files {
+		if err := bp.extractFile(dir, name, params, dryRun); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func Generate(stanzaName string, stanzaBaseDir string) error {
+// BlueprintDir returns the directory of user-defined blueprints in the provider.
+func BlueprintDir(stanzaBaseDir string) string {
+	return path.Join(stanzaBaseDir, "blueprints")
+}
+
+func Generate(stanzaName string, stanzaBaseDir string, opts Options) error {
+	if err := ValidateName(stanzaName); err != nil {
+		return err
+	}
+
+	name := opts.Blueprint
+	if name == "" {
+		name = DefaultBlueprint
+	}
+	bp, err := FindBlueprint(name, opts.BlueprintDirs)
+	if err != nil {
+		return err
+	}
+
 	stanzaDir := path.Join(stanzaBaseDir, stanzaName)
-	log.Printf("creating stanza directory %#q", stanzaDir)
+	if _, err := os.Stat(stanzaDir); err == nil && !opts.Force {
+		return fmt.Errorf("stanza directory %s already exists (use -force to overwrite)", stanzaDir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	log.Printf("creating stanza directory %#q from blueprint %s", stanzaDir, bp.Name)
 
 	t := time.Now()
 	params := parameters{
-		Name:    stanzaName,
-		Created: t.Format("2006-01-02"),
-		Updated: t.Format("2006-01-02"),
+		Name:       stanzaName,
+		Created:    t.Format("2006-01-02"),
+		Updated:    t.Format("2006-01-02"),
+		Label:      opts.Label,
+		Definition: opts.Definition,
+		Author:     OrDefault(opts.Author, "author name"),
+		Address:    OrDefault(opts.Address, "name@example.org"),
+		Provider:   OrDefault(opts.Provider, "provider of this stanza"),
+		License:    opts.License,
+		Parameters: opts.Parameters,
 	}
-	return extractBlueprintAssets(stanzaDir, "blueprint", &params)
+	return bp.extract(stanzaDir, &params, opts.DryRun)
 }
 
-func MustTemplateAsset(path string) *template.Template {
-	data, err := Asset(path)
-	if err != nil {
-		panic("asset " + path + "not found")
+// OrDefault returns s, or def if s is empty.
+func OrDefault(s, def string) string {
+	if s == "" {
+		return def
 	}
-
-	return template.Must(template.New(path).Parse(string(data)))
+	return s
 }
@@ -0,0 +1,217 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps the outputs in memory. It is also an http.FileSystem to serve
+// them. Relative names are resolved from the root.
+type Memory struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+	dirs  map[string]time.Time
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory returns an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{
+		files: make(map[string]*memFile),
+		dirs:  map[string]time.Time{"/": time.Now()},
+	}
+}
+
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+// isUnder reports whether name is dir or under dir.
+func isUnder(name, dir string) bool {
+	return name == dir || dir == "/" || strings.HasPrefix(name, dir+"/")
+}
+
+// mkdirAll must be called with m.mu held.
+func (m *Memory) mkdirAll(dir string, t time.Time) {
+	for ; ; dir = path.Dir(dir) {
+		if _, ok := m.dirs[dir]; ok {
+			return
+		}
+		m.dirs[dir] = t
+	}
+}
+
+func (m *Memory) MkdirAll(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dir = clean(dir)
+	if _, ok := m.files[dir]; ok {
+		return &os.PathError{Op: "mkdir", Path: dir, Err: os.ErrExist}
+	}
+	m.mkdirAll(dir, time.Now())
+	return nil
+}
+
+func (m *Memory) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = clean(name)
+	for p := range m.files {
+		if isUnder(p, name) {
+			delete(m.files, p)
+		}
+	}
+	for p := range m.dirs {
+		if isUnder(p, name) && p != "/" {
+			delete(m.dirs, p)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) WriteFile(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = clean(name)
+	if _, ok := m.dirs[name]; ok {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	t := time.Now()
+	m.mkdirAll(path.Dir(name), t)
+	m.files[name] = &memFile{data: append([]byte{}, data...), modTime: t}
+	return nil
+}
+
+func (m *Memory) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	name = clean(name)
+	f, ok := m.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return append([]byte{}, f.data...), nil
+}
+
+func (m *Memory) Walk(root string, fn func(name string) error) error {
+	m.mu.RLock()
+	root = clean(root)
+	names := []string{}
+	for p := range m.files {
+		if isUnder(p, root) {
+			names = append(names, p)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		if err := fn(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open implements http.FileSystem.
+func (m *Memory) Open(name string) (http.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	name = clean(name)
+	if f, ok := m.files[name]; ok {
+		info := &fileInfo{name: path.Base(name), size: int64(len(f.data)), modTime: f.modTime}
+		return &file{Reader: bytes.NewReader(f.data), info: info}, nil
+	}
+	modTime, ok := m.dirs[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	entries := []os.FileInfo{}
+	for p, f := range m.files {
+		if p != "/" && path.Dir(p) == name {
+			entries = append(entries, &fileInfo{name: path.Base(p), size: int64(len(f.data)), modTime: f.modTime})
+		}
+	}
+	for p, t := range m.dirs {
+		if p != "/" && path.Dir(p) == name {
+			entries = append(entries, &fileInfo{name: path.Base(p), modTime: t, dir: true})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	info := &fileInfo{name: path.Base(name), modTime: modTime, dir: true}
+	return &file{Reader: bytes.NewReader(nil), info: info, entries: entries}, nil
+}
+
+// file is an http.File of Memory. The contents are a snapshot at the time of Open.
+type file struct {
+	*bytes.Reader
+	info    *fileInfo
+	entries []os.FileInfo // entries not read by Readdir yet
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.dir {
+		return nil, &os.PathError{Op: "readdir", Path: f.info.name, Err: os.ErrInvalid}
+	}
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
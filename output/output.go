@@ -0,0 +1,59 @@
+// Package output provides the file systems the outputs of builds are written to.
+package output
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FS is a file system build outputs are written to. Names are slash-separated paths.
+type FS interface {
+	// MkdirAll creates the directory dir and its parents.
+	MkdirAll(dir string) error
+
+	// RemoveAll removes name and its children if any. It returns nil if name
+	// does not exist.
+	RemoveAll(name string) error
+
+	// WriteFile writes data to the file name, whose directory must exist.
+	WriteFile(name string, data []byte) error
+
+	// ReadFile returns the contents of the file name.
+	ReadFile(name string) ([]byte, error)
+
+	// Walk calls fn for each file under root in lexical order. Directories
+	// are not passed to fn.
+	Walk(root string, fn func(name string) error) error
+}
+
+// Disk writes the outputs to the local file system.
+type Disk struct{}
+
+func (Disk) MkdirAll(dir string) error {
+	return os.MkdirAll(filepath.FromSlash(dir), os.FileMode(0755))
+}
+
+func (Disk) RemoveAll(name string) error {
+	return os.RemoveAll(filepath.FromSlash(name))
+}
+
+func (Disk) WriteFile(name string, data []byte) error {
+	return ioutil.WriteFile(filepath.FromSlash(name), data, os.FileMode(0644))
+}
+
+func (Disk) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.FromSlash(name))
+}
+
+func (Disk) Walk(root string, fn func(name string) error) error {
+	return filepath.Walk(filepath.FromSlash(root), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsDir() {
+			return nil
+		}
+		return fn(filepath.ToSlash(p))
+	})
+}
@@ -18,9 +18,57 @@ function groupBy(array, func) {
   return ret;
 }
 
+function coerce(param, value) {
+  switch (param.type) {
+    case 'number':
+      return Number(value);
+    case 'boolean':
+      return typeof value === 'boolean' ? value : value !== 'false';
+    case 'json':
+      return typeof value === 'string' ? JSON.parse(value) : value;
+    default:
+      return typeof value === 'string' ? value : String(value);
+  }
+}
+
+function parameterValue(param, attrValue) {
+  if (attrValue === null) {
+    return param.default === undefined ? null : coerce(param, param.default);
+  }
+
+  const value = coerce(param, attrValue);
+
+  if (param.type === 'number' && isNaN(value)) {
+    throw new Error(`parameter "${param.key}": "${attrValue}" is not a number`);
+  }
+  if (param.type === 'enum' && !param.choices.map(String).includes(value)) {
+    throw new Error(`parameter "${param.key}": "${attrValue}" is not one of ${param.choices.join(', ')}`);
+  }
+  if (param.pattern && !new RegExp(`^(?:${param.pattern})$`).test(attrValue)) {
+    throw new Error(`parameter "${param.key}": "${attrValue}" does not match the pattern ${param.pattern}`);
+  }
+
+  return value;
+}
+
+function proxiedEndpoint(proxy, endpoint) {
+  if (!proxy || !(proxy.endpoints.includes('*') || proxy.endpoints.includes(endpoint))) {
+    return endpoint;
+  }
+  return `${proxy.url}?endpoint=${encodeURIComponent(endpoint)}`;
+}
+
+function queryURL(descriptor, params) {
+  if (descriptor.fixturesUrl) {
+    return `${descriptor.fixturesUrl}?template=${encodeURIComponent(params.template)}&endpoint=${encodeURIComponent(params.endpoint)}`;
+  }
+  return proxiedEndpoint(descriptor.sparqlProxy, params.endpoint);
+}
+
 export default function initialize(descriptor) {
   return function Stanza(execute) {
     const development = descriptor.development;
+    const parameterKeys = descriptor.parameters.map((param) => param.key);
 
     function template(name) {
       const t = descriptor.templates[name];
@@ -46,14 +94,20 @@ export default function initialize(descriptor) {
           const query = queryTemplate(params.parameters);
           const data = new URLSearchParams();
           data.set("query", query);
+          if (descriptor.fixturesUrl) {
+            // recorded with the fixture by ts record
+            data.set("parameters", JSON.stringify(params.parameters || {}));
+          }
+
+          const endpoint = queryURL(descriptor, params);
 
           if (development) {
             console.log("query: query built:\n" + query);
-            console.log("query: sending to", params.endpoint);
+            console.log("query: sending to", endpoint);
           }
 
           // NOTE specifying Content-Type explicitly because some browsers sends `application/x-www-form-urlencoded;charset=UTF-8` without this, and some endpoints may not support this form.
-          return fetch(params.endpoint, {
+          return fetch(endpoint, {
             method: params.method || "POST",
             headers: {
               "Content-Type": "application/x-www-form-urlencoded",
@@ -152,7 +206,16 @@ export default function initialize(descriptor) {
     }
 
     const update = debounce((element) => {
-      const params = descriptor.parameters.reduce((acc, key) => Object.assign(acc, {[key]: element.getAttribute(key)}), {});
+      const params = {};
+
+      try {
+        descriptor.parameters.forEach((param) => {
+          params[param.key] = parameterValue(param, element.getAttribute(param.key));
+        });
+      } catch (e) {
+        console.error(`${descriptor.elementName}: ${e.message}`);
+        return;
+      }
 
       execute(createStanzaHelper(element), params);
     }, 50);
@@ -169,11 +232,11 @@ export default function initialize(descriptor) {
       }
 
       static get observedAttributes() {
-        return descriptor.parameters;
+        return parameterKeys;
       }
 
       attributeChangedCallback(attrName, oldVal, newVal) {
-        if (!descriptor.parameters.includes(attrName)) { return; }
+        if (!parameterKeys.includes(attrName)) { return; }
 
         update(this);
       }
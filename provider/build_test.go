@@ -0,0 +1,108 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/togostanza/ts/stanza"
+)
+
+var referencePatterns = map[string][]*regexp.Regexp{
+	".html": {regexp.MustCompile(`(?:src|href)="([^"]+)"`)},
+	".css": {
+		regexp.MustCompile(`url\(\s*["']?([^"')]+)`),
+		regexp.MustCompile(`@import\s+["']([^"']+)`),
+	},
+	".js": {regexp.MustCompile("[\"'`]((?:\\.{1,2}/)*assets/[^\"'`?#\\s\\\\]+)")},
+}
+
+// checkReferences reports the references in the files built into distDir
+// which do not resolve to files.
+func checkReferences(t *testing.T, distDir, basePath string) {
+	err := filepath.Walk(distDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p == filepath.Join(distDir, "assets") {
+				return filepath.SkipDir // the provider's own
+			}
+			return nil
+		}
+		patterns := referencePatterns[filepath.Ext(p)]
+		if patterns == nil {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(distDir, p)
+		for _, pattern := range patterns {
+			for _, m := range pattern.FindAllStringSubmatch(string(data), -1) {
+				ref := strings.TrimSpace(m[1])
+				if strings.Contains(ref, "{{") || strings.Contains(ref, "://") || strings.HasPrefix(ref, "//") ||
+					strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "mailto:") {
+					continue
+				}
+				ref = strings.SplitN(strings.SplitN(ref, "#", 2)[0], "?", 2)[0]
+				var target string
+				if strings.HasPrefix(ref, "/") {
+					if !strings.HasPrefix(ref, basePath) {
+						continue
+					}
+					target = strings.TrimPrefix(ref, basePath)
+				} else {
+					target = path.Join(path.Dir(filepath.ToSlash(rel)), ref)
+				}
+				if _, err := os.Stat(filepath.Join(distDir, filepath.FromSlash(target))); err != nil {
+					t.Errorf("%s: %s does not resolve to a file", rel, m[1])
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildReferences(t *testing.T) {
+	for _, opts := range []stanza.BuildOptions{
+		{Development: true, BasePath: "/stanza/"},
+		{BasePath: "/stanza/"},
+		{BasePath: "/stanza/", HashAssets: true, Gzip: true},
+		{BasePath: "/stanza/", HashAssets: true, InlineAssetsLimit: 100},
+	} {
+		dir, err := ioutil.TempDir("", "ts-build")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		sp, err := New("testdata/assets")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sp.Build(dir, opts); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("development %v, hash assets %v, inline assets limit %d", opts.Development, opts.HashAssets, opts.InlineAssetsLimit)
+		checkReferences(t, dir, opts.BasePath)
+
+		if opts.HashAssets {
+			module, err := ioutil.ReadFile(filepath.Join(dir, "s-hello", "s-hello.js"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(module), "assets/style.css") {
+				t.Errorf("s-hello.js refers to assets/style.css instead of its hashed name")
+			}
+		}
+	}
+}
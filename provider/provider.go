@@ -1,179 +1,570 @@
 package provider
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"runtime"
 	"sort"
+	"strings"
+	"sync"
 	"text/template"
 	"time"
 
+	"github.com/togostanza/ts/output"
 	"github.com/togostanza/ts/stanza"
 )
 
 //go:generate go-bindata -pkg=provider data/... assets/...
 
 type StanzaProvider struct {
-	baseDir      string
-	stanzas      map[string]*stanza.Stanza
-	lastModified time.Time
+	baseDir string
+	stanzas map[string]*stanza.Stanza
+	jobs    int
+	filter  func(name string) bool
+
+	sources []string // directories containing stanzas, in the order of priority
+	depth   int      // depth of the stanza directories under the sources
+	ignore  []string // directories not searched for stanzas
+
+	// state of the last build, used to rebuild only updated stanzas
+	builtDistDir string
+	builtOptions stanza.BuildOptions
+	fingerprints map[string]string
+	rebuilt      []string // stanzas built in the last build, successfully or not
+
+	mu          sync.Mutex                    // guards stanzas and buildErrors against the readers outside builds
+	buildErrors map[string]*stanza.BuildError // last error of each stanza
 }
 
 func New(baseDir string) (*StanzaProvider, error) {
 	sp := StanzaProvider{
-		baseDir: baseDir,
+		baseDir:     baseDir,
+		sources:     []string{baseDir},
+		depth:       1,
+		jobs:        runtime.GOMAXPROCS(0),
+		buildErrors: make(map[string]*stanza.BuildError),
 	}
 
 	return &sp, nil
 }
 
-func (sp *StanzaProvider) LastModified() (time.Time, error) {
-	var t time.Time
-	err := filepath.Walk(sp.baseDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// Errors is a list of errors occurred in different stanzas.
+type Errors []error
+
+func (errs Errors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d error(s) occurred:\n%s", len(errs), strings.Join(messages, "\n"))
+}
+
+// SetJobs sets the number of stanzas built concurrently.
+func (sp *StanzaProvider) SetJobs(n int) {
+	if n < 1 {
+		n = 1
+	}
+	sp.jobs = n
+}
+
+// SetFilter sets the function to select stanzas by the paths of their
+// directories relative to the source. All stanzas are selected if filter is nil.
+func (sp *StanzaProvider) SetFilter(filter func(rel string) bool) {
+	sp.filter = filter
+}
+
+// SetSources sets the directories containing stanzas. Stanzas are searched
+// down to depth levels of directories under each of them; 1 means only the
+// direct subdirectories. If stanzas of the same name are found, the one in
+// the earlier source is used.
+func (sp *StanzaProvider) SetSources(sources []string, depth int) {
+	if len(sources) == 0 {
+		sources = []string{sp.baseDir}
+	}
+	if depth < 1 {
+		depth = 1
+	}
+	sp.sources = sources
+	sp.depth = depth
+}
+
+// SetIgnore sets the directories not searched for stanzas, such as the
+// output directory.
+func (sp *StanzaProvider) SetIgnore(dirs []string) {
+	sp.ignore = nil
+	for _, dir := range dirs {
+		sp.ignore = append(sp.ignore, filepath.Clean(dir))
+	}
+}
+
+func (sp *StanzaProvider) ignored(dir string) bool {
+	dir = filepath.Clean(dir)
+	for _, ignore := range sp.ignore {
+		if dir == ignore {
+			return true
 		}
-		rel, err := filepath.Rel(sp.baseDir, path)
+	}
+	return false
+}
+
+// skippedDirs are directory names never searched for stanzas.
+var skippedDirs = map[string]bool{
+	"node_modules": true,
+	"blueprints":   true,
+	"dist":         true,
+}
+
+// SkippedDir reports whether directories named name are never searched for
+// stanzas: those in skippedDirs and hidden ones such as .git.
+func SkippedDir(name string) bool {
+	return skippedDirs[name] || strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+// stanzaDir is a directory of a stanza found in a source.
+type stanzaDir struct {
+	source string
+	dir    string
+	rel    string // relative path from the source, separated by slashes
+}
+
+func (sd stanzaDir) name() string {
+	return filepath.Base(sd.dir)
+}
+
+func (sd stanzaDir) metadataPath() string {
+	return filepath.Join(sd.dir, "metadata.json")
+}
+
+// stanzaDirs returns the directories of the stanzas in the sources.
+func (sp *StanzaProvider) stanzaDirs() ([]stanzaDir, error) {
+	dirs := []stanzaDir{}
+	for _, source := range sp.sources {
+		source = filepath.Clean(source)
+		err := filepath.Walk(source, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() || p == source {
+				return nil
+			}
+			if sp.ignored(p) || SkippedDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(source, p)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+
+			if _, err := os.Stat(filepath.Join(p, "metadata.json")); err == nil {
+				if sp.filter == nil || sp.filter(rel) {
+					dirs = append(dirs, stanzaDir{source: source, dir: p, rel: rel})
+				}
+				return filepath.SkipDir // stanzas are not nested
+			}
+			if strings.Count(rel, "/")+1 >= sp.depth {
+				return filepath.SkipDir
+			}
+			return nil
+		})
 		if err != nil {
-			return err
-		}
-		if rel == "dist" {
-			return filepath.SkipDir
+			return nil, err
 		}
-		mt := info.ModTime()
-		if mt.After(t) {
-			t = mt
-		}
-		return nil
-	})
-	return t, err
+	}
+	return dirs, nil
 }
 
+// sourceName returns the source relative to the base directory if it is
+// under it, or as is otherwise.
+func (sp *StanzaProvider) sourceName(source string) string {
+	rel, err := filepath.Rel(sp.baseDir, source)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return source
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Load loads the stanzas in the sources. Stanzas which fail to load, or
+// whose names collide with the stanzas in the earlier sources, are skipped,
+// and their errors are returned together as Errors.
 func (sp *StanzaProvider) Load() error {
-	stanzaMetadataPaths, err := filepath.Glob(path.Join(sp.baseDir, "*/metadata.json"))
+	dirs, err := sp.stanzaDirs()
 	if err != nil {
 		return err
 	}
 
 	stanzas := make(map[string]*stanza.Stanza)
-	for _, stanzaMetadataPath := range stanzaMetadataPaths {
-		stanzaPath := filepath.Dir(stanzaMetadataPath)
-		stanzaName := filepath.Base(stanzaPath)
-		log.Printf("loading stanza %s", stanzaPath)
-		stanza, err := stanza.NewStanza(path.Join(stanzaPath), stanzaName)
+	found := make(map[string]stanzaDir)
+	errs := Errors{}
+	for _, sd := range dirs {
+		stanzaName := sd.name()
+		if first, ok := found[stanzaName]; ok {
+			errs = append(errs, &stanza.BuildError{
+				Stanza: stanzaName,
+				File:   sd.dir,
+				Err:    fmt.Errorf("stanza %q is already defined in %s", stanzaName, first.dir),
+			})
+			continue
+		}
+		found[stanzaName] = sd
+
+		log.Printf("loading stanza %s", sd.dir)
+		stanza, err := stanza.NewStanza(sd.dir, stanzaName)
 		if err != nil {
-			return err
+			sp.setBuildError(stanzaName, err)
+			errs = append(errs, err)
+			continue
 		}
+		stanza.SetSource(sp.sourceName(sd.source))
 		stanzas[stanzaName] = stanza
 	}
+	sp.mu.Lock()
 	sp.stanzas = stanzas
+	for name := range sp.buildErrors {
+		if _, ok := found[name]; !ok {
+			delete(sp.buildErrors, name)
+		}
+	}
+	sp.mu.Unlock()
 
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
 
-func (sp *StanzaProvider) build(distDir string, development bool) error {
-	t0 := time.Now()
+// BuildError returns the error of the last build of the stanza, or nil if it
+// was built successfully.
+func (sp *StanzaProvider) BuildError(name string) *stanza.BuildError {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.buildErrors[name]
+}
+
+// BuildErrors returns the errors of the stanzas which failed in the last build.
+func (sp *StanzaProvider) BuildErrors() []*stanza.BuildError {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	errs := make([]*stanza.BuildError, 0, len(sp.buildErrors))
+	for _, be := range sp.buildErrors {
+		errs = append(errs, be)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Stanza < errs[j].Stanza
+	})
+	return errs
+}
+
+func (sp *StanzaProvider) setBuildError(name string, err error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if err == nil {
+		delete(sp.buildErrors, name)
+		return
+	}
+	be, ok := err.(*stanza.BuildError)
+	if !ok {
+		be = &stanza.BuildError{Stanza: name, Err: err}
+	}
+	sp.buildErrors[name] = be
+	delete(sp.fingerprints, name) // make sure to rebuild it next time
+}
+
+// Lint checks metadata of every stanza under the base directory, including
+// the ones whose metadata cannot be parsed, which Load skips.
+func (sp *StanzaProvider) Lint() ([]stanza.Diagnostic, error) {
+	dirs, err := sp.stanzaDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	diagnostics := []stanza.Diagnostic{}
+	found := make(map[string]stanzaDir)
+	for _, sd := range dirs {
+		if first, ok := found[sd.name()]; ok {
+			diagnostics = append(diagnostics, stanza.Diagnostic{
+				File:     sd.metadataPath(),
+				Severity: stanza.SeverityError,
+				Message:  fmt.Sprintf("stanza %q is already defined in %s", sd.name(), first.dir),
+			})
+			continue
+		}
+		found[sd.name()] = sd
 
+		st := &stanza.Stanza{
+			BaseDir: sd.dir,
+			Name:    sd.name(),
+		}
+		diagnostics = append(diagnostics, st.Lint()...)
+	}
+
+	return diagnostics, nil
+}
+
+// FixUsage rewrites stanza:usage of the stanzas whose usage is empty or does
+// not match their parameters, and returns the paths of the files changed.
+// If names is not empty, only the named stanzas are fixed. As Build, it fixes
+// the rest of the stanzas even if some fail to load or to be fixed, and
+// returns their errors together as Errors.
+func (sp *StanzaProvider) FixUsage(names []string) ([]string, error) {
+	errs := Errors{}
 	if err := sp.Load(); err != nil {
-		return err
+		loadErrs, ok := err.(Errors)
+		if !ok {
+			return nil, err
+		}
+		errs = append(errs, loadErrs...)
+	}
+
+	if len(names) == 0 {
+		for _, st := range sp.Stanzas() {
+			names = append(names, st.Name)
+		}
+	}
+
+	fixed := []string{}
+	for _, name := range names {
+		st := sp.Stanza(name)
+		if st == nil {
+			if sp.BuildError(name) == nil {
+				// otherwise reported as an error of Load
+				errs = append(errs, fmt.Errorf("stanza %q is not found", name))
+			}
+			continue
+		}
+		changed, err := st.FixUsage()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if changed {
+			fixed = append(fixed, st.MetadataPath())
+		}
+	}
+	if len(errs) > 0 {
+		return fixed, errs
+	}
+	return fixed, nil
+}
+
+// Build builds the stanzas into distDir. Only the stanzas whose inputs have
+// changed since the last build with the same distDir and opts are rebuilt.
+func (sp *StanzaProvider) Build(distDir string, opts stanza.BuildOptions) error {
+	t0 := time.Now()
+	sp.rebuilt = nil
+
+	// stanzas failed to load are reported after the rest are built
+	loadErr := sp.Load()
+	if _, ok := loadErr.(Errors); loadErr != nil && !ok {
+		return loadErr
 	}
 
 	if sp.NumStanzas() == 0 {
-		return fmt.Errorf("no stanzas available under %s", sp.baseDir)
+		if loadErr != nil {
+			return loadErr
+		}
+		return fmt.Errorf("no stanzas available under %s", strings.Join(sp.sources, ", "))
 	}
 
-	if err := os.RemoveAll(distDir); err != nil {
-		return err
+	if opts.HashAssets && opts.AssetNames == nil {
+		assetNames, err := hashedAssetNames(opts)
+		if err != nil {
+			return err
+		}
+		opts.AssetNames = assetNames
 	}
-	if err := os.MkdirAll(distDir, os.FileMode(0755)); err != nil {
-		return err
+
+	out := opts.OutputFS()
+	incremental := sp.fingerprints != nil && sp.builtDistDir == distDir && reflect.DeepEqual(sp.builtOptions, opts)
+	if !incremental {
+		if err := out.RemoveAll(distDir); err != nil {
+			return err
+		}
+		if err := out.MkdirAll(distDir); err != nil {
+			return err
+		}
+		sp.fingerprints = make(map[string]string)
+		sp.builtDistDir = distDir
+		sp.builtOptions = opts
 	}
 
-	if err := sp.buildStanzas(distDir, development); err != nil {
+	if err := sp.removeDeletedStanzas(out, distDir); err != nil {
 		return err
 	}
-	if err := sp.extractAssets(distDir); err != nil {
+	stanzasErr := sp.buildStanzas(distDir, opts)
+	if _, ok := stanzasErr.(Errors); stanzasErr != nil && !ok {
+		return stanzasErr
+	}
+	if !incremental {
+		if err := sp.extractAssets(distDir, opts); err != nil {
+			return err
+		}
+	}
+	if err := sp.buildList(distDir, opts); err != nil {
 		return err
 	}
-	if err := sp.buildList(distDir); err != nil {
+	if err := sp.buildMetadata(out, distDir); err != nil {
 		return err
 	}
-	if err := sp.buildMetadata(distDir); err != nil {
+	if err := sp.buildSearchIndex(out, distDir); err != nil {
 		return err
 	}
+	if opts.HashAssets {
+		if err := sp.buildManifest(distDir, opts); err != nil {
+			return err
+		}
+	}
+	if opts.Gzip {
+		if err := compressOutputs(out, distDir); err != nil {
+			return err
+		}
+	}
 
 	log.Println("built in", time.Since(t0))
-	return nil
-}
 
-func (sp *StanzaProvider) Build(distDir string, development bool) error {
-	lm, err := sp.LastModified()
-	if err != nil {
-		return err
+	errs := Errors{}
+	if loadErr != nil {
+		errs = append(errs, loadErr.(Errors)...)
 	}
-	sp.lastModified = lm
-
-	if err := sp.build(distDir, development); err != nil {
-		return err
+	if stanzasErr != nil {
+		errs = append(errs, stanzasErr.(Errors)...)
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool {
+			return errs[i].Error() < errs[j].Error()
+		})
+		return errs
 	}
 	return nil
 }
 
-func (sp *StanzaProvider) RebuildIfRequired(distDir string, development bool) error {
-	lm, err := sp.LastModified()
-	if err != nil {
-		return err
+type stanzaBuild struct {
+	stanza      *stanza.Stanza
+	fingerprint string
+	log         bytes.Buffer
+	err         error
+}
+
+func (sp *StanzaProvider) buildStanzas(distDir string, opts stanza.BuildOptions) error {
+	if opts.Development {
+		log.Println("building stanzas (development mode)")
+	} else {
+		log.Println("building stanzas (production mode)")
 	}
 
-	if lm.After(sp.lastModified) {
-		sp.lastModified = lm
-		log.Println("update detected; rebuilding ...")
-		if err := sp.Build(distDir, development); err != nil {
+	builds := []*stanzaBuild{}
+	for _, stanza := range sp.Stanzas() {
+		fingerprint, err := stanza.Fingerprint()
+		if err != nil {
 			return err
 		}
+		if sp.fingerprints[stanza.Name] == fingerprint {
+			continue
+		}
+		delete(sp.fingerprints, stanza.Name)
+		builds = append(builds, &stanzaBuild{stanza: stanza, fingerprint: fingerprint})
+		sp.rebuilt = append(sp.rebuilt, stanza.Name)
+	}
+
+	queue := make(chan *stanzaBuild)
+	done := make(chan *stanzaBuild)
+	for i := 0; i < sp.jobs; i++ {
+		go func() {
+			for b := range queue {
+				b.err = sp.buildStanza(b, distDir, opts)
+				done <- b
+			}
+		}()
+	}
+	go func() {
+		for _, b := range builds {
+			queue <- b
+		}
+		close(queue)
+	}()
+
+	for range builds {
+		b := <-done
+		os.Stderr.Write(b.log.Bytes()) // keep the log of each stanza together
+	}
+
+	errs := Errors{}
+	for _, b := range builds {
+		if b.err != nil {
+			sp.setBuildError(b.stanza.Name, b.err)
+			errs = append(errs, b.err)
+			continue
+		}
+		sp.setBuildError(b.stanza.Name, nil)
+		sp.fingerprints[b.stanza.Name] = b.fingerprint
+	}
+
+	log.Printf("%d stanza(s) built, %d up to date", len(builds)-len(errs), sp.NumStanzas()-len(builds))
+	if len(errs) > 0 {
+		return errs
 	}
 	return nil
 }
 
-func (sp *StanzaProvider) buildStanzas(distDir string, development bool) error {
-	if development {
-		log.Println("building stanzas (development mode)")
-	} else {
-		log.Println("building stanzas (production mode)")
+func (sp *StanzaProvider) buildStanza(b *stanzaBuild, distDir string, opts stanza.BuildOptions) error {
+	st := b.stanza
+	st.Logger = log.New(&b.log, "", log.LstdFlags)
+	defer func() { st.Logger = nil }()
+
+	destStanzaBase := path.Join(distDir, st.Name)
+	if err := opts.OutputFS().RemoveAll(destStanzaBase); err != nil {
+		return err
 	}
-	numBuilt := 0
-	for name, stanza := range sp.stanzas {
+	return st.Build(destStanzaBase, opts)
+}
+
+func (sp *StanzaProvider) removeDeletedStanzas(out output.FS, distDir string) error {
+	for name := range sp.fingerprints {
+		if sp.Stanza(name) != nil {
+			continue
+		}
 		destStanzaBase := path.Join(distDir, name)
-		if err := stanza.Build(destStanzaBase, development); err != nil {
+		if err := out.RemoveAll(destStanzaBase); err != nil {
 			return err
 		}
-		numBuilt++
+		delete(sp.fingerprints, name)
+		log.Printf("removed %s", destStanzaBase)
 	}
-
-	log.Printf("%d stanza(s) built", numBuilt)
 	return nil
 }
 
-func (sp *StanzaProvider) buildList(distDir string) error {
+func (sp *StanzaProvider) buildList(distDir string, opts stanza.BuildOptions) error {
 	tmpl := MustTemplateAsset("data/list.html")
 
 	destPath := path.Join(distDir, "index.html")
-	w, err := os.Create(destPath)
-	if err != nil {
-		return err
-	}
-	defer w.Close()
+
+	broken := sp.BuildErrors()
+	stanzas := sp.builtStanzas()
 
 	context := struct {
-		Stanzas []*stanza.Stanza
+		Stylesheet string
+		Stanzas    []*stanza.Stanza
+		Broken     []*stanza.BuildError
+		Facets     []facet
 	}{
-		Stanzas: sp.Stanzas(),
+		Stylesheet: "./" + opts.AssetName("assets/css/ts.css"),
+		Stanzas:    stanzas,
+		Broken:     broken,
+		Facets:     facets(stanzas),
 	}
 
-	if err := tmpl.Execute(w, context); err != nil {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, context); err != nil {
+		return err
+	}
+	if err := opts.OutputFS().WriteFile(destPath, buf.Bytes()); err != nil {
 		return err
 	}
 
@@ -182,15 +573,95 @@ func (sp *StanzaProvider) buildList(distDir string) error {
 	return nil
 }
 
-func (sp *StanzaProvider) buildMetadata(distDir string) error {
-	destPath := path.Join(distDir, "metadata.json")
-	w, err := os.Create(destPath)
-	if err != nil {
+// facet is a property of stanzas to filter the list of stanzas by.
+type facet struct {
+	Key    string
+	Label  string
+	Values []string
+}
+
+func facets(stanzas []*stanza.Stanza) []facet {
+	facets := []facet{
+		{Key: "context", Label: "Context"},
+		{Key: "display", Label: "Display"},
+		{Key: "license", Label: "License"},
+	}
+	for i := range facets {
+		seen := make(map[string]bool)
+		for _, st := range stanzas {
+			v := facetValue(st, facets[i].Key)
+			if v != "" && !seen[v] {
+				seen[v] = true
+				facets[i].Values = append(facets[i].Values, v)
+			}
+		}
+		sort.Strings(facets[i].Values)
+	}
+	return facets
+}
+
+func facetValue(st *stanza.Stanza, key string) string {
+	switch key {
+	case "context":
+		return st.Context
+	case "display":
+		return st.Display
+	case "license":
+		return st.License
+	}
+	return ""
+}
+
+type searchIndexEntry struct {
+	Name       string   `json:"name"`
+	Url        string   `json:"url"`
+	Label      string   `json:"label"`
+	Definition string   `json:"definition"`
+	Tags       []string `json:"tags"`
+	Author     string   `json:"author"`
+	Context    string   `json:"context"`
+	Display    string   `json:"display"`
+	License    string   `json:"license"`
+	Parameters []string `json:"parameters"`
+}
+
+// buildSearchIndex writes search-index.json used by the list of stanzas to search stanzas.
+func (sp *StanzaProvider) buildSearchIndex(out output.FS, distDir string) error {
+	destPath := path.Join(distDir, "search-index.json")
+
+	entries := []searchIndexEntry{}
+	for _, st := range sp.builtStanzas() {
+		entries = append(entries, searchIndexEntry{
+			Name:       st.Name,
+			Url:        st.Name + "/help.html",
+			Label:      st.Label,
+			Definition: st.Definition,
+			Tags:       st.Tags(),
+			Author:     st.Author,
+			Context:    st.Context,
+			Display:    st.Display,
+			License:    st.License,
+			Parameters: st.ParameterKeys(),
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(entries); err != nil {
 		return err
 	}
-	defer w.Close()
+	if err := out.WriteFile(destPath, buf.Bytes()); err != nil {
+		return err
+	}
+
+	log.Printf("generated %s", destPath)
+
+	return nil
+}
+
+func (sp *StanzaProvider) buildMetadata(out output.FS, distDir string) error {
+	destPath := path.Join(distDir, "metadata.json")
 
-	stanzas := sp.Stanzas()
+	stanzas := sp.builtStanzas()
 	metadataArray := make([]interface{}, len(stanzas))
 	for i := range metadataArray {
 		metadataArray[i] = stanzas[i].MetadataRaw
@@ -203,8 +674,11 @@ func (sp *StanzaProvider) buildMetadata(distDir string) error {
 		"stanza:stanzas": metadataArray,
 	}
 
-	encoder := json.NewEncoder(w)
-	if err := encoder.Encode(metadata); err != nil {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(metadata); err != nil {
+		return err
+	}
+	if err := out.WriteFile(destPath, buf.Bytes()); err != nil {
 		return err
 	}
 
@@ -213,41 +687,208 @@ func (sp *StanzaProvider) buildMetadata(distDir string) error {
 	return nil
 }
 
-func (sp *StanzaProvider) extractAssets(distStanzaPath string) error {
+func (sp *StanzaProvider) extractAssets(distStanzaPath string, opts stanza.BuildOptions) error {
 	assetsToExtract := []string{
-		"assets/components/webcomponentsjs/webcomponents-ce.js",
-		"assets/components/webcomponentsjs/webcomponents-ce.js.map",
-		"assets/components/webcomponentsjs/webcomponents-hi-ce.js",
-		"assets/components/webcomponentsjs/webcomponents-hi-ce.js.map",
-		"assets/components/webcomponentsjs/webcomponents-hi-sd-ce.js",
-		"assets/components/webcomponentsjs/webcomponents-hi-sd-ce.js.map",
-		"assets/components/webcomponentsjs/webcomponents-hi-sd.js",
-		"assets/components/webcomponentsjs/webcomponents-hi-sd.js.map",
-		"assets/components/webcomponentsjs/webcomponents-hi.js",
-		"assets/components/webcomponentsjs/webcomponents-hi.js.map",
-		"assets/components/webcomponentsjs/webcomponents-lite.js",
-		"assets/components/webcomponentsjs/webcomponents-lite.js.map",
-		"assets/components/webcomponentsjs/webcomponents-loader.js",
-		"assets/components/webcomponentsjs/webcomponents-sd-ce.js",
-		"assets/components/webcomponentsjs/webcomponents-sd-ce.js.map",
-		"assets/components/webcomponentsjs/webcomponents-sd.js",
-		"assets/components/webcomponentsjs/webcomponents-sd.js.map",
 		"assets/css/ts.css",
-		"assets/js/stanza.js",
-		"assets/js/stanza.js.map",
+		"assets/js/stanza.esm.js",
+		"assets/js/stanza.esm.js.map",
+	}
+	if opts.HtmlImport {
+		assetsToExtract = append(assetsToExtract, htmlImportAssets...)
 	}
 	for _, asset := range assetsToExtract {
-		err := RestoreAsset(distStanzaPath, asset)
+		data, err := Asset(asset)
 		if err != nil {
 			return err
 		}
-		log.Printf("generated %s", path.Join(distStanzaPath, asset))
+		destPath := path.Join(distStanzaPath, opts.AssetName(asset))
+		if err := opts.OutputFS().MkdirAll(path.Dir(destPath)); err != nil {
+			return err
+		}
+		if err := opts.OutputFS().WriteFile(destPath, data); err != nil {
+			return err
+		}
+		log.Printf("generated %s", destPath)
 	}
 
 	return nil
 }
 
+// hashedAssetNames maps the provider's assets to content-hashed names.
+// Source maps and the polyfills, which are referred by fixed names, are kept.
+func hashedAssetNames(opts stanza.BuildOptions) (map[string]string, error) {
+	names := make(map[string]string)
+	for _, asset := range AssetNames() {
+		if strings.HasSuffix(asset, ".map") || strings.HasPrefix(asset, "assets/components/") || !strings.HasPrefix(asset, "assets/") {
+			continue
+		}
+		data, err := Asset(asset)
+		if err != nil {
+			return nil, err
+		}
+		names[asset] = stanza.HashedName(asset, data)
+	}
+	return names, nil
+}
+
+type manifestEntry struct {
+	Path      string `json:"path"`
+	Integrity string `json:"integrity"`
+}
+
+// buildManifest writes manifest.json which maps the logical name of each file
+// in distDir to its actual path and integrity hash.
+func (sp *StanzaProvider) buildManifest(distDir string, opts stanza.BuildOptions) error {
+	logicalNames := make(map[string]string)
+	for logical, hashed := range opts.AssetNames {
+		logicalNames[hashed] = logical
+	}
+	for _, st := range sp.Stanzas() {
+		names, err := st.HashedAssetNames()
+		if err != nil {
+			return err
+		}
+		for logical, hashed := range names {
+			logicalNames[path.Join(st.Name, hashed)] = path.Join(st.Name, logical)
+		}
+	}
+
+	out := opts.OutputFS()
+	destPath := path.Join(distDir, "manifest.json")
+	manifest := make(map[string]manifestEntry)
+	err := out.Walk(distDir, func(p string) error {
+		if p == destPath {
+			return nil
+		}
+		rel, err := filepath.Rel(distDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		data, err := out.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		logical, ok := logicalNames[rel]
+		if !ok {
+			logical = rel
+		}
+		manifest[logical] = manifestEntry{
+			Path:      rel,
+			Integrity: stanza.Integrity(data),
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := out.WriteFile(destPath, data); err != nil {
+		return err
+	}
+
+	log.Printf("generated %s", destPath)
+
+	return nil
+}
+
+// compressibleExts are the extensions of the text files compressed by
+// compressOutputs.
+var compressibleExts = map[string]bool{
+	".html": true,
+	".js":   true,
+	".css":  true,
+	".json": true,
+	".map":  true,
+	".svg":  true,
+	".txt":  true,
+	".xml":  true,
+	".csv":  true,
+	".tsv":  true,
+}
+
+// minCompressSize is the size in bytes below which files are not compressed.
+const minCompressSize = 256
+
+// compressOutputs writes the gzip-compressed variant of each text file in
+// distDir as "<name>.gz", unless it is not smaller than the file.
+func compressOutputs(out output.FS, distDir string) error {
+	paths := []string{}
+	err := out.Walk(distDir, func(p string) error {
+		if compressibleExts[path.Ext(p)] {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	for _, p := range paths {
+		data, err := out.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if len(data) < minCompressSize {
+			continue
+		}
+
+		var buf bytes.Buffer
+		zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return err
+		}
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if buf.Len() >= len(data) {
+			continue
+		}
+		if err := out.WriteFile(p+".gz", buf.Bytes()); err != nil {
+			return err
+		}
+		n++
+	}
+
+	log.Printf("compressed %d file(s) with gzip", n)
+
+	return nil
+}
+
+// htmlImportAssets are the assets required by stanzas loaded with HTML Imports.
+var htmlImportAssets = []string{
+	"assets/components/webcomponentsjs/webcomponents-ce.js",
+	"assets/components/webcomponentsjs/webcomponents-ce.js.map",
+	"assets/components/webcomponentsjs/webcomponents-hi-ce.js",
+	"assets/components/webcomponentsjs/webcomponents-hi-ce.js.map",
+	"assets/components/webcomponentsjs/webcomponents-hi-sd-ce.js",
+	"assets/components/webcomponentsjs/webcomponents-hi-sd-ce.js.map",
+	"assets/components/webcomponentsjs/webcomponents-hi-sd.js",
+	"assets/components/webcomponentsjs/webcomponents-hi-sd.js.map",
+	"assets/components/webcomponentsjs/webcomponents-hi.js",
+	"assets/components/webcomponentsjs/webcomponents-hi.js.map",
+	"assets/components/webcomponentsjs/webcomponents-lite.js",
+	"assets/components/webcomponentsjs/webcomponents-lite.js.map",
+	"assets/components/webcomponentsjs/webcomponents-loader.js",
+	"assets/components/webcomponentsjs/webcomponents-sd-ce.js",
+	"assets/components/webcomponentsjs/webcomponents-sd-ce.js.map",
+	"assets/components/webcomponentsjs/webcomponents-sd.js",
+	"assets/components/webcomponentsjs/webcomponents-sd.js.map",
+	"assets/js/stanza.js",
+	"assets/js/stanza.js.map",
+}
+
 func (sp *StanzaProvider) Stanzas() []*stanza.Stanza {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
 	stanzas := make([]*stanza.Stanza, len(sp.stanzas))
 	i := 0
 	for _, stanza := range sp.stanzas {
@@ -260,11 +901,33 @@ func (sp *StanzaProvider) Stanzas() []*stanza.Stanza {
 	return stanzas
 }
 
+// Rebuilt returns the names of the stanzas built in the last build,
+// successfully or not. Stanzas which were up to date are not included.
+func (sp *StanzaProvider) Rebuilt() []string {
+	return sp.rebuilt
+}
+
+// builtStanzas returns the stanzas except the ones which failed in the last
+// build, to be listed in the outputs for all stanzas.
+func (sp *StanzaProvider) builtStanzas() []*stanza.Stanza {
+	stanzas := []*stanza.Stanza{}
+	for _, st := range sp.Stanzas() {
+		if sp.BuildError(st.Name) == nil {
+			stanzas = append(stanzas, st)
+		}
+	}
+	return stanzas
+}
+
 func (sp *StanzaProvider) Stanza(name string) *stanza.Stanza {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
 	return sp.stanzas[name]
 }
 
 func (sp *StanzaProvider) NumStanzas() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
 	return len(sp.stanzas)
 }
 
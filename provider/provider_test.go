@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/togostanza/ts/output"
+	"github.com/togostanza/ts/stanza"
+)
+
+const fixturesDir = "../cypress/fixtures/provider"
+
+// TestConcurrentBuild reads the stanzas while they are rebuilt, as the server
+// does; run with -race.
+func TestConcurrentBuild(t *testing.T) {
+	sp, err := New(fixturesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := stanza.BuildOptions{Development: true, Output: output.NewMemory()}
+	if err := sp.Build("/", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, st := range sp.Stanzas() {
+				sp.Stanza(st.Name)
+				sp.BuildError(st.Name)
+			}
+			sp.NumStanzas()
+			sp.BuildErrors()
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := sp.Build("/", opts); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if n := sp.NumStanzas(); n != 2 {
+		t.Errorf("NumStanzas() = %d, want 2", n)
+	}
+}
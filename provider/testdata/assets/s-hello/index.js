@@ -0,0 +1,12 @@
+Stanza(function(stanza, params) {
+  const link = document.createElement("link");
+  link.rel = "stylesheet";
+  link.href = new URL("assets/style.css", import.meta.url).href;
+  stanza.root.appendChild(link);
+  stanza.render({
+    template: "stanza.html",
+    parameters: {
+      logo: `assets/img/logo.svg`
+    }
+  });
+});
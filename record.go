@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/togostanza/ts/provider"
+	"github.com/togostanza/ts/sparql"
+	"github.com/togostanza/ts/stanza"
+)
+
+var cmdRecord = &Command{
+	Run:       runRecord,
+	Name:      "record",
+	Short:     "record SPARQL fixtures of a stanza",
+	UsageLine: "record [-stanza-base-dir dir] [-source dir]... [-depth n] [-endpoint url] [-template name]... [stanza name]",
+	Long:      "Record the responses to the queries of a stanza into its fixtures directory. The query templates are rendered with the examples of the parameters in metadata.json, and sent to the endpoint given by the endpoint parameter unless -endpoint is given. `ts server -replay` serves the recorded responses to the stanzas instead of the endpoints.",
+}
+
+// fixturesPath is the path of the fixtures, relative to the base path.
+const fixturesPath = "_ts/fixtures"
+
+var (
+	flagRecordEndpoint  string
+	flagRecordTemplates stringsFlag
+)
+
+func init() {
+	addBuildFlags(cmdRecord)
+	addSourceFlags(cmdRecord)
+	cmdRecord.Flag.StringVar(&flagRecordEndpoint, "endpoint", "", "SPARQL endpoint to send the queries to, instead of the endpoint parameter of the stanza")
+	cmdRecord.Flag.Var(&flagRecordTemplates, "template", "query template to record (repeatable; defaults to all templates other than HTML ones)")
+}
+
+func runRecord(cmd *Command, args []string) {
+	if len(args) != 1 {
+		cmd.Flag.Usage()
+		os.Exit(2)
+	}
+
+	sp, err := newProvider()
+	if err != nil {
+		log.Fatal(err)
+	}
+	loadErr := sp.Load()
+	st := sp.Stanza(args[0])
+	if st == nil {
+		if loadErr != nil {
+			log.Fatal(loadErr)
+		}
+		log.Fatalf("stanza %q is not found", args[0])
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	paths, err := recordFixtures(client, st, flagRecordEndpoint, flagRecordTemplates)
+	for _, p := range paths {
+		log.Printf("recorded %s", p)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// recordFixtures records the fixtures of the templates of st, or all of its
+// query templates if templates is empty, and returns the paths of the files.
+// The queries are sent to endpoint, or the endpoint parameter if it is empty.
+func recordFixtures(client *http.Client, st *stanza.Stanza, endpoint string, templates []string) ([]string, error) {
+	params, err := st.Metadata.ExampleParameters()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", st.MetadataPath(), err)
+	}
+	if endpoint == "" {
+		endpoint, _ = params["endpoint"].(string)
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("no endpoint to record from; give -endpoint, or an example of the endpoint parameter of %s", st.Name)
+	}
+
+	if len(templates) == 0 {
+		if templates, err = st.QueryTemplates(); err != nil {
+			return nil, err
+		}
+		if len(templates) == 0 {
+			return nil, fmt.Errorf("stanza %s has no query templates", st.Name)
+		}
+	}
+
+	paths := []string{}
+	failed := []string{}
+	for _, template := range templates {
+		p, err := recordFixture(client, st, template, endpoint, params)
+		if err != nil {
+			log.Printf("ERROR while recording %s: %s", template, err)
+			failed = append(failed, template)
+			continue
+		}
+		paths = append(paths, p)
+	}
+	if len(failed) > 0 {
+		return paths, fmt.Errorf("failed to record %s of %s", strings.Join(failed, ", "), st.Name)
+	}
+	return paths, nil
+}
+
+// recordFixture sends the query rendered from template to endpoint, and saves
+// the response as a fixture of st.
+func recordFixture(client *http.Client, st *stanza.Stanza, template, endpoint string, params map[string]interface{}) (string, error) {
+	query, err := st.RenderQuery(template, params)
+	if err != nil {
+		return "", err
+	}
+	res, status, err := sparql.Send(client, endpoint, query, sparql.DefaultAccept)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("%s responded %d %s", endpoint, status, http.StatusText(status))
+	}
+
+	return st.SaveFixture(&stanza.Fixture{
+		Template:    template,
+		Parameters:  params,
+		Endpoint:    endpoint,
+		Query:       query,
+		ContentType: res.ContentType,
+		Body:        res.Body,
+		Recorded:    res.Fetched,
+	})
+}
+
+// fixturesHandler responds to the queries of stanzas under prefix with the
+// recorded fixtures. The name of the stanza follows prefix. The template is
+// given as the template parameter, and the parameters it is rendered with as
+// the parameters form value, in JSON.
+func fixturesHandler(sp *provider.StanzaProvider, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		st := sp.Stanza(strings.TrimPrefix(req.URL.Path, prefix))
+		if st == nil {
+			http.NotFound(w, req)
+			return
+		}
+		template := req.URL.Query().Get("template")
+		if template == "" || req.FormValue("parameters") == "" {
+			http.Error(w, "template and parameters are required", http.StatusBadRequest)
+			return
+		}
+		var params map[string]interface{}
+		if err := json.Unmarshal([]byte(req.FormValue("parameters")), &params); err != nil {
+			http.Error(w, fmt.Sprintf("invalid parameters: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		f, err := st.Fixture(template, params)
+		if err != nil {
+			log.Println("ERROR while reading fixture:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if f == nil {
+			log.Printf("%s: no fixture of %s for the parameters %s; run `ts record %s` to record the examples", st.Name, template, req.FormValue("parameters"), st.Name)
+			http.Error(w, fmt.Sprintf("no fixture of %s for the parameters (replay mode)", template), http.StatusNotFound)
+			return
+		}
+		if query := req.FormValue("query"); query != "" && strings.Join(strings.Fields(query), " ") != strings.Join(strings.Fields(f.Query), " ") {
+			log.Printf("%s: %s has changed since its fixture was recorded; run `ts record %s` to record it again", st.Name, template, st.Name)
+		}
+		if f.ContentType != "" {
+			w.Header().Set("Content-Type", f.ContentType)
+		}
+		w.Write([]byte(f.Body))
+	})
+}
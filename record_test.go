@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/togostanza/ts/provider"
+	"github.com/togostanza/ts/stanza"
+)
+
+const recordTestMetadata = `{
+  "@context": {
+    "stanza": "http://togostanza.org/resource/stanza#"
+  },
+  "@id": "query",
+  "stanza:label": "Query",
+  "stanza:definition": "Stanza querying an endpoint.",
+  "stanza:parameter": [
+    {"stanza:key": "endpoint", "stanza:type": "url", "stanza:example": "http://example.org/sparql", "stanza:required": true},
+    {"stanza:key": "limit", "stanza:type": "number", "stanza:example": "10"},
+    {"stanza:key": "taxa", "stanza:type": "json", "stanza:example": "[\"9606\", \"10090\"]"}
+  ],
+  "stanza:usage": "<togostanza-query endpoint=\"http://example.org/sparql\"></togostanza-query>",
+  "stanza:type": "Stanza"
+}`
+
+const recordTestQuery = `SELECT * WHERE {
+  VALUES ?taxon { {{#each taxa}}"{{this}}" {{/each}}}
+}
+{{#if limit}}
+LIMIT {{limit}}
+{{/if}}
+`
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"query/metadata.json":         recordTestMetadata,
+		"query/templates/stanza.rq":   recordTestQuery,
+		"query/templates/stanza.html": "<p>{{rows}}</p>",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantQuery := "SELECT * WHERE {\n  VALUES ?taxon { \"9606\" \"10090\" }\n}\nLIMIT 10\n"
+	body := `{"head": {"vars": []}, "results": {"bindings": []}}`
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if q := req.FormValue("query"); q != wantQuery {
+			t.Errorf("endpoint received %q, want %q", q, wantQuery)
+		}
+		w.Header().Set("Content-Type", "application/sparql-results+json")
+		w.Write([]byte(body))
+	}))
+	defer endpoint.Close()
+
+	sp, err := provider.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Load(); err != nil {
+		t.Fatal(err)
+	}
+	st := sp.Stanza("query")
+
+	paths, err := recordFixtures(endpoint.Client(), st, endpoint.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || filepath.Dir(paths[0]) != st.FixturesDir() || !strings.HasPrefix(filepath.Base(paths[0]), "stanza.rq-") {
+		t.Fatalf("recordFixtures() = %q, want a fixture of stanza.rq", paths)
+	}
+	data, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f stanza.Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Template != "stanza.rq" || f.Endpoint != endpoint.URL || f.Query != wantQuery || f.Body != body || f.ContentType != "application/sparql-results+json" {
+		t.Errorf("fixture = %+v", f)
+	}
+
+	// the fixture is replayed for the same template and parameters, as sent
+	// by the runtime, whatever the query is
+	prefix := "/stanza/" + fixturesPath + "/"
+	h := fixturesHandler(sp, prefix)
+	replay := func(template, params string) *httptest.ResponseRecorder {
+		form := url.Values{"query": {"SELECT * WHERE {}"}, "parameters": {params}}
+		req := httptest.NewRequest(http.MethodPost, prefix+"query?template="+template, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	params := `{"taxa": ["9606", "10090"], "limit": 10, "endpoint": "http://example.org/sparql"}`
+	if w := replay("stanza.rq", params); w.Code != http.StatusOK || w.Body.String() != body || w.Header().Get("Content-Type") != "application/sparql-results+json" {
+		t.Errorf("replay: %d %q", w.Code, w.Body.String())
+	}
+	for _, tt := range []struct {
+		template, params string
+		code             int
+	}{
+		{"stanza.rq", `{"taxa": ["9606"], "limit": 10, "endpoint": "http://example.org/sparql"}`, http.StatusNotFound},
+		{"other.rq", params, http.StatusNotFound},
+		{"stanza.rq", "", http.StatusBadRequest},
+		{"stanza.rq", "{", http.StatusBadRequest},
+	} {
+		if w := replay(tt.template, tt.params); w.Code != tt.code {
+			t.Errorf("replay of %s with %q: %d, want %d", tt.template, tt.params, w.Code, tt.code)
+		}
+	}
+}
+
+func TestRecordErrors(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "bad query", http.StatusBadRequest)
+	}))
+	defer endpoint.Close()
+
+	dir, err := ioutil.TempDir("", "ts-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "query", "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "query", "metadata.json"), []byte(recordTestMetadata), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := stanza.NewStanza(filepath.Join(dir, "query"), "query")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := recordFixtures(endpoint.Client(), st, endpoint.URL, nil); err == nil || !strings.Contains(err.Error(), "no query templates") {
+		t.Errorf("recordFixtures() without templates = %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "query", "templates", "stanza.rq"), []byte(recordTestQuery), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recordFixtures(endpoint.Client(), st, endpoint.URL, nil); err == nil || !strings.Contains(err.Error(), "failed to record stanza.rq") {
+		t.Errorf("recordFixtures() with an endpoint responding 400 = %v", err)
+	}
+	if _, err := os.Stat(st.FixturesDir()); !os.IsNotExist(err) {
+		t.Errorf("fixtures are recorded for an endpoint responding 400")
+	}
+}
@@ -3,22 +3,42 @@ import commonjs from 'rollup-plugin-commonjs';
 import resolve from 'rollup-plugin-node-resolve';
 import { uglify } from 'rollup-plugin-uglify';
 
-export default {
-  input: 'provider/assets-src/js/stanza.js',
-  output: {
-    file: 'provider/assets/js/stanza.js',
-    format: 'iife',
-    name: 'TogoStanza.initialize',
-    sourcemap: true
+export default [
+  {
+    input: 'provider/assets-src/js/stanza.js',
+    output: {
+      file: 'provider/assets/js/stanza.js',
+      format: 'iife',
+      name: 'TogoStanza.initialize',
+      sourcemap: true
+    },
+    plugins: [
+      babel({
+        exclude: 'node_modules/**'
+      }),
+      resolve(),
+      commonjs({
+        include: 'node_modules/**'
+      }),
+      uglify()
+    ]
   },
-  plugins: [
-    babel({
-      exclude: 'node_modules/**'
-    }),
-    resolve(),
-    commonjs({
-      include: 'node_modules/**'
-    }),
-    uglify()
-  ]
-};
+  {
+    input: 'provider/assets-src/js/stanza.js',
+    output: {
+      file: 'provider/assets/js/stanza.esm.js',
+      format: 'esm',
+      sourcemap: true
+    },
+    plugins: [
+      babel({
+        exclude: 'node_modules/**'
+      }),
+      resolve(),
+      commonjs({
+        include: 'node_modules/**'
+      })
+      // uglify() does not support ES modules
+    ]
+  }
+];
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var cmdServe = &Command{
+	Run:       runServe,
+	Name:      "serve",
+	Short:     "serve built stanzas",
+	UsageLine: "serve [-port port] [-stanza-base-dir dir] [-out dir] [-base-path path] [-cors-origin origin]... [-cors-credentials] [-cors-header header]... [-cors-max-age seconds] [-shutdown-timeout duration]",
+	Long:      "Serve the stanzas built by ts build for production. The output directory is served as is, with validators and caching headers. Gzip-compressed variants of files (.gz, written by ts build -gzip) are served to the clients accepting them. On SIGTERM or SIGINT, the server stops accepting connections and waits for the requests in progress.",
+}
+
+var flagServeShutdownTimeout time.Duration
+
+func init() {
+	cmdServe.Flag.IntVar(&flagPort, "port", 8080, "port to listen on")
+	cmdServe.Flag.DurationVar(&flagServeShutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to wait for the requests in progress on shutdown")
+	addBuildFlags(cmdServe)
+	addOutputFlags(cmdServe)
+	addCORSFlags(cmdServe)
+}
+
+func runServe(cmd *Command, args []string) {
+	distStanzaPath := conf.OutDir(flagStanzaBaseDir)
+	if info, err := os.Stat(distStanzaPath); err != nil || !info.IsDir() {
+		log.Fatalf("%s is not a directory; run `ts build` first", distStanzaPath)
+	}
+
+	mux := http.NewServeMux()
+	basePath := conf.BasePath
+	if basePath != "/" {
+		mux.Handle("/", redirectToBasePath(basePath))
+	}
+	files := &staticHandler{dir: distStanzaPath, etags: make(map[string]etagEntry)}
+	mux.Handle(basePath, http.StripPrefix(strings.TrimSuffix(basePath, "/"), withCORS(conf.CORS, files)))
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", flagPort),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		log.Printf("received %s; shutting down", <-sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), flagServeShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("ERROR during shutdown:", err)
+		}
+	}()
+
+	log.Printf("listening on %s, serving %s at %s", srv.Addr, distStanzaPath, basePath)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+}
+
+// staticHandler serves the files in dir. Directories are not listed; index.html
+// is served instead.
+type staticHandler struct {
+	dir string
+
+	mu    sync.Mutex
+	etags map[string]etagEntry // by the path of the file
+
+	// paths of the files with content-hashed names in manifest.json, reloaded
+	// when it is modified
+	manifestModTime time.Time
+	hashedPaths     map[string]bool
+}
+
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + req.URL.Path)
+	if strings.HasSuffix(req.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			http.NotFound(w, req)
+			return
+		}
+	}
+
+	filePath := filepath.Join(h.dir, filepath.FromSlash(name))
+	info, err := os.Stat(filePath)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	if info.IsDir() {
+		// relative to the request, since the prefix is stripped from req.URL
+		target := path.Base(req.URL.Path) + "/"
+		if req.URL.RawQuery != "" {
+			target += "?" + req.URL.RawQuery
+		}
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Accept-Encoding")
+	header.Set("X-Content-Type-Options", "nosniff")
+	if h.isHashed(name) {
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		header.Set("Cache-Control", "public, no-cache")
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+
+	// gzip variants are written by ts build -gzip
+	if acceptsEncoding(req.Header.Get("Accept-Encoding"), "gzip") {
+		if variant, err := os.Stat(filePath + ".gz"); err == nil && variant.Mode().IsRegular() {
+			header.Set("Content-Encoding", "gzip")
+			filePath += ".gz"
+		}
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer f.Close()
+	info, err = f.Stat()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	etag, err := h.etag(filePath, info, f)
+	if err != nil {
+		log.Println("ERROR:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	header.Set("ETag", etag)
+
+	http.ServeContent(w, req, name, info.ModTime(), f)
+}
+
+// isHashed reports whether the file of name has a content-hashed name, which
+// is listed in manifest.json written by ts build -hash-assets.
+func (h *staticHandler) isHashed(name string) bool {
+	manifestPath := filepath.Join(h.dir, "manifest.json")
+	info, err := os.Stat(manifestPath)
+	if err != nil {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hashedPaths == nil || !info.ModTime().Equal(h.manifestModTime) {
+		paths, err := readHashedPaths(manifestPath)
+		if err != nil {
+			log.Println("ERROR while reading manifest:", err)
+			return false
+		}
+		h.hashedPaths = paths
+		h.manifestModTime = info.ModTime()
+	}
+	return h.hashedPaths[strings.TrimPrefix(name, "/")]
+}
+
+// readHashedPaths returns the paths in the manifest which differ from their
+// logical names, i.e. the ones with content-hashed names.
+func readHashedPaths(manifestPath string) (map[string]bool, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var manifest map[string]struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%s: %s", manifestPath, err)
+	}
+	paths := make(map[string]bool)
+	for logical, entry := range manifest {
+		if entry.Path != logical {
+			paths[entry.Path] = true
+		}
+	}
+	return paths, nil
+}
+
+// etag returns the entity tag of the file derived from its content. Tags are
+// cached until the file is modified.
+func (h *staticHandler) etag(filePath string, info os.FileInfo, f io.ReadSeeker) (string, error) {
+	h.mu.Lock()
+	entry, ok := h.etags[filePath]
+	h.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.etag, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil))[:16] + `"`
+
+	h.mu.Lock()
+	h.etags[filePath] = etagEntry{size: info.Size(), modTime: info.ModTime(), etag: etag}
+	h.mu.Unlock()
+	return etag, nil
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows encoding.
+func acceptsEncoding(header, encoding string) bool {
+	accepted := false
+	for _, item := range strings.Split(header, ",") {
+		params := strings.Split(item, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding != encoding && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if coding == encoding {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
@@ -4,65 +4,242 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"path"
+	"path/filepath"
 	"regexp"
+	"strings"
+	"time"
 
+	"github.com/togostanza/ts/output"
 	"github.com/togostanza/ts/provider"
+	"github.com/togostanza/ts/stanza"
+	"github.com/togostanza/ts/watcher"
 )
 
 var cmdServer = &Command{
 	Run:       runServer,
 	Name:      "server",
 	Short:     "run server",
-	UsageLine: "server [-port port] [-stanza-base-dir dir] [-development]",
-	Long:      "Run ts server for development",
+	UsageLine: "server [-port port] [-stanza-base-dir dir] [-source dir]... [-depth n] [-out dir] [-base-path path] [-development] [-watch-poll] [-in-memory] [-html-import] [-cors-origin origin]... [-cors-credentials] [-cors-header header]... [-cors-max-age seconds] [-tls] [-tls-cert file -tls-key file] [-sparql-endpoint url]... [-sparql-cache dir] [-sparql-replay] [-replay]",
+	Long:      "Run ts server for development. Stanzas are built in memory unless -in-memory=false is given, leaving the output directory untouched.",
+}
+
+// buildEventsPath is the path of the build events under the base path.
+const buildEventsPath = "_ts/events"
+
+// stanzaPathRegexp returns the pattern of the paths of stanza files served under basePath.
+func stanzaPathRegexp(basePath string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(basePath) + `([^/]+)/(.*)$`)
 }
 
-var REGEXP_STANZA_PATH = regexp.MustCompile(`^/stanza/([^/]+)/`)
 var flagServerDevelopment bool
+var flagServerWatchPoll bool
+var flagServerInMemory bool
+var flagServerReplay bool
 
 func init() {
 	cmdServer.Flag.IntVar(&flagPort, "port", 8080, "port to listen on")
 	cmdServer.Flag.BoolVar(&flagServerDevelopment, "development", true, "development mode")
+	cmdServer.Flag.BoolVar(&flagServerWatchPoll, "watch-poll", false, "poll files for changes instead of using filesystem events")
+	cmdServer.Flag.BoolVar(&flagServerInMemory, "in-memory", true, "build stanzas in memory instead of the output directory")
+	cmdServer.Flag.BoolVar(&flagServerReplay, "replay", false, "respond to queries of stanzas with the fixtures recorded by ts record, instead of the endpoints")
 	addBuildFlags(cmdServer)
+	addSourceFlags(cmdServer)
+	addOutputFlags(cmdServer)
+	addHtmlImportFlag(cmdServer)
+	addCORSFlags(cmdServer)
+	addTLSFlags(cmdServer)
+	addSparqlProxyFlags(cmdServer)
 }
 
 func runServer(cmd *Command, args []string) {
-	sp, err := provider.New(flagStanzaBaseDir)
+	var certFile, keyFile string
+	if tlsEnabled() {
+		var err error
+		if certFile, keyFile, err = tlsCertificateFiles(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	sp, err := newProvider()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	distPath := path.Join(flagStanzaBaseDir, "dist")
-	distStanzaPath := path.Join(distPath, "stanza")
-	if err := sp.Build(distStanzaPath, flagServerDevelopment); err != nil {
-		log.Fatal(err)
+	distStanzaPath := conf.OutDir(flagStanzaBaseDir)
+	opts := stanza.BuildOptions{
+		Development: flagServerDevelopment,
+		HtmlImport:  flagHtmlImport,
+		BaseURL:     conf.BaseURL,
+		BasePath:    conf.BasePath,
+	}
+	if flagServerDevelopment {
+		opts.BuildEventsURL = conf.BasePath + buildEventsPath
+	}
+	proxy, proxyOpts := newSparqlProxy()
+	opts.SparqlProxy = proxyOpts
+	if flagServerReplay {
+		opts.FixturesURL = conf.BasePath + fixturesPath
+	}
+	buildDir := distStanzaPath
+	var root http.FileSystem = http.Dir(distStanzaPath)
+	if flagServerInMemory {
+		mem := output.NewMemory()
+		opts.Output = mem
+		buildDir = "/"
+		root = mem
+	}
+	if err := sp.Build(buildDir, opts); err != nil {
+		// errors in stanzas are shown in place of them; keep serving to wait for a fix
+		if !isStanzaError(err) {
+			log.Fatal(err)
+		}
+		log.Println("ERROR during build:", err)
 	}
 
+	changes := make(chan struct{}, 1)
+	ignoreDirs := watcher.IgnoreDirs(distStanzaPath, conf.SparqlCacheDir(flagStanzaBaseDir))
+	for _, dir := range watchRoots(flagStanzaBaseDir, conf.SourceDirs(flagStanzaBaseDir)) {
+		root := filepath.Clean(dir)
+		ignore := func(path string) bool {
+			if filepath.Clean(path) == root {
+				return false
+			}
+			// neither node_modules nor .git affect the stanzas, and
+			// recording fixtures does not need a rebuild
+			return ignoreDirs(path) || provider.SkippedDir(filepath.Base(path)) || stanza.IsFixturesDir(path)
+		}
+		w, err := watcher.New(dir, ignore, flagServerWatchPoll, 500*time.Millisecond)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+		go func() {
+			for range w.Events() {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+
+	events := newBuildEvents()
+	go func() {
+		for range changes {
+			log.Println("update detected; rebuilding ...")
+			err := sp.Build(buildDir, opts)
+			if err != nil {
+				log.Println("ERROR during rebuild:", err)
+			}
+			events.publish(buildResults(sp, err))
+		}
+	}()
+
 	mux := http.NewServeMux()
-	assetsHandler := http.FileServer(http.Dir(distPath))
+	basePath := conf.BasePath
+	assetsHandler := http.StripPrefix(strings.TrimSuffix(basePath, "/"), http.FileServer(root))
+	stanzaPath := stanzaPathRegexp(basePath)
 
-	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		if req.URL.Path == "/" {
-			http.Redirect(w, req, "/stanza/", http.StatusFound)
-			return
+	mux.Handle(basePath+buildEventsPath, withCORS(conf.CORS, events))
+	if proxy != nil {
+		mux.Handle(sparqlProxyPath, withCORS(conf.CORS, proxy))
+		if proxy.Replay {
+			log.Printf("SPARQL proxy at %s replays the responses in %s", sparqlProxyPath, proxy.Cache.Dir)
+		} else {
+			log.Printf("SPARQL proxy at %s forwards queries to %s", sparqlProxyPath, strings.Join(proxy.Endpoints, ", "))
 		}
-		if m := REGEXP_STANZA_PATH.FindStringSubmatch(req.URL.Path); len(m) > 0 {
-			if m[1] != "assets" {
-				err := sp.RebuildIfRequired(distStanzaPath, flagServerDevelopment)
-				if err != nil {
-					log.Println("ERROR during rebuild:", err)
-				}
+	}
+	if flagServerReplay {
+		prefix := basePath + fixturesPath + "/"
+		mux.Handle(prefix, withCORS(conf.CORS, fixturesHandler(sp, prefix)))
+		log.Printf("stanzas query the fixtures at %s", prefix)
+	}
+	if basePath != "/" {
+		mux.Handle("/", redirectToBasePath(basePath))
+	}
+	mux.Handle(basePath, withCORS(conf.CORS, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if m := stanzaPath.FindStringSubmatch(req.URL.Path); len(m) > 0 {
+			if be := sp.BuildError(m[1]); be != nil && serveBuildError(w, be, m[2], opts) {
+				return
 			}
 		}
 		assetsHandler.ServeHTTP(w, req)
-	})
+	})))
 
 	addr := fmt.Sprintf(":%d", flagPort)
-	log.Println("listening on", addr)
+	log.Printf("listening on %s, serving stanzas at %s", addr, basePath)
+	for _, url := range serverURLs(tlsEnabled(), flagPort, basePath) {
+		log.Printf("  %s", url)
+	}
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	if tlsEnabled() {
+		err = http.ListenAndServeTLS(addr, certFile, keyFile, mux)
+	} else {
+		err = http.ListenAndServe(addr, mux)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// redirectToBasePath redirects the requests to the root and basePath without
+// the trailing slash to basePath, and responds 404 to the others.
+func redirectToBasePath(basePath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" && req.URL.Path != strings.TrimSuffix(basePath, "/") {
+			http.NotFound(w, req)
+			return
+		}
+		http.Redirect(w, req, basePath, http.StatusFound)
+	})
+}
+
+// watchRoots returns the directories to watch: the stanza base directory and
+// the sources outside of it.
+func watchRoots(baseDir string, sources []string) []string {
+	roots := []string{baseDir}
+	for _, source := range sources {
+		rel, err := filepath.Rel(baseDir, source)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			roots = append(roots, source)
+		}
+	}
+	return roots
+}
+
+func isStanzaError(err error) bool {
+	switch e := err.(type) {
+	case *stanza.BuildError:
+		return true
+	case provider.Errors:
+		for _, err := range e {
+			if !isStanzaError(err) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serveBuildError responds with be in place of the stanza's file built with
+// opts, and reports whether it has responded.
+func serveBuildError(w http.ResponseWriter, be *stanza.BuildError, file string, opts stanza.BuildOptions) bool {
+	switch file {
+	case "", "index.html", "help.html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		if err := be.WriteHTML(w, opts); err != nil {
+			log.Println("ERROR while writing error page:", err)
+		}
+	case "metadata.json":
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		if err := be.WriteJSON(w); err != nil {
+			log.Println("ERROR while writing error page:", err)
+		}
+	default:
+		return false
+	}
+	return true
+}
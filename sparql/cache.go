@@ -0,0 +1,84 @@
+package sparql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Response is a response of a SPARQL endpoint to a query.
+type Response struct {
+	Endpoint    string    `json:"endpoint"`
+	Accept      string    `json:"accept"`
+	Query       string    `json:"query"`
+	ContentType string    `json:"contentType"`
+	Body        string    `json:"body"`
+	Fetched     time.Time `json:"fetched"`
+}
+
+// Cache stores responses in a directory, one file for each combination of an
+// endpoint, the media type requested and a query.
+type Cache struct {
+	Dir string
+}
+
+func (c *Cache) path(endpoint, accept, query string) string {
+	sum := sha256.Sum256([]byte(endpoint + "\x00" + accept + "\x00" + query))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get returns the cached response to query sent to endpoint requesting the
+// media type accept, or nil if it is not cached.
+func (c *Cache) Get(endpoint, accept, query string) (*Response, error) {
+	data, err := ioutil.ReadFile(c.path(endpoint, accept, query))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res Response
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Put stores res.
+func (c *Cache) Put(res *Response) error {
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, os.FileMode(0755)); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(res.Endpoint, res.Accept, res.Query), data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to name, so
+// that readers never see a partially written file.
+func writeFileAtomic(name string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), os.FileMode(0644)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
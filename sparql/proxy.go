@@ -0,0 +1,153 @@
+// Package sparql forwards queries of stanzas to SPARQL endpoints, keeping the
+// responses for offline development.
+package sparql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAccept is the media type requested from endpoints if the client does
+// not specify one.
+const DefaultAccept = "application/sparql-results+json"
+
+// Proxy is an http.Handler which forwards SPARQL queries to the endpoint
+// given as the endpoint parameter. The query is taken from the query
+// parameter of the URL or the form.
+type Proxy struct {
+	// Endpoints are the endpoints queries may be forwarded to. Other
+	// endpoints are rejected, so that the proxy is not an open relay.
+	Endpoints []string
+
+	// Cache stores the responses if non-nil.
+	Cache *Cache
+
+	// Replay makes the proxy respond only from Cache, without accessing the
+	// endpoints. If Endpoints is empty, queries to any endpoint are replayed.
+	Replay bool
+
+	// Client sends the queries. http.DefaultClient is used if nil.
+	Client *http.Client
+}
+
+// Allows reports whether the proxy responds to queries to endpoint.
+func (p *Proxy) Allows(endpoint string) bool {
+	if p.Replay && len(p.Endpoints) == 0 {
+		return true
+	}
+	for _, e := range p.Endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	endpoint := req.URL.Query().Get("endpoint")
+	query := req.FormValue("query")
+	if endpoint == "" || query == "" {
+		http.Error(w, "endpoint and query are required", http.StatusBadRequest)
+		return
+	}
+	if !p.Allows(endpoint) {
+		http.Error(w, fmt.Sprintf("endpoint %s is not allowed", endpoint), http.StatusForbidden)
+		return
+	}
+
+	accept := req.Header.Get("Accept")
+	if accept == "" || accept == "*/*" {
+		accept = DefaultAccept
+	}
+
+	if p.Cache != nil {
+		res, err := p.Cache.Get(endpoint, accept, query)
+		if err != nil {
+			log.Println("ERROR while reading SPARQL cache:", err)
+		}
+		if res != nil {
+			writeResponse(w, res, "hit")
+			return
+		}
+	}
+	if p.Replay {
+		log.Printf("sparql-proxy: no cached response from %s to:\n%s", endpoint, query)
+		http.Error(w, fmt.Sprintf("no cached response from %s to the query (replay mode)", endpoint), http.StatusGatewayTimeout)
+		return
+	}
+
+	res, status, err := Send(p.Client, endpoint, query, accept)
+	if err != nil {
+		log.Printf("sparql-proxy: %s: %s", endpoint, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if status != http.StatusOK {
+		w.Header().Set("Content-Type", res.ContentType)
+		w.WriteHeader(status)
+		w.Write([]byte(res.Body))
+		return
+	}
+	if p.Cache != nil {
+		if err := p.Cache.Put(res); err != nil {
+			log.Println("ERROR while writing SPARQL cache:", err)
+		}
+	}
+	writeResponse(w, res, "miss")
+}
+
+// Send sends query to endpoint with client, requesting the media type accept,
+// and returns the response with its status code. http.DefaultClient is used
+// if client is nil.
+func Send(client *http.Client, endpoint, query, accept string) (*Response, int, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	form := url.Values{"query": {query}}
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", accept)
+
+	t0 := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	log.Printf("%s: %s in %s", endpoint, resp.Status, time.Since(t0))
+
+	return &Response{
+		Endpoint:    endpoint,
+		Accept:      accept,
+		Query:       query,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        string(body),
+		Fetched:     time.Now(),
+	}, resp.StatusCode, nil
+}
+
+func writeResponse(w http.ResponseWriter, res *Response, cacheStatus string) {
+	if res.ContentType != "" {
+		w.Header().Set("Content-Type", res.ContentType)
+	}
+	w.Header().Set("X-Ts-Cache", cacheStatus)
+	w.Write([]byte(res.Body))
+}
@@ -0,0 +1,80 @@
+package sparql
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	requests := 0
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		accept := req.Header.Get("Accept")
+		w.Header().Set("Content-Type", accept)
+		w.Write([]byte(accept + ": " + req.FormValue("query")))
+	}))
+	defer endpoint.Close()
+
+	dir, err := ioutil.TempDir("", "ts-sparql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	proxy := &Proxy{Endpoints: []string{endpoint.URL}, Cache: &Cache{Dir: dir}}
+	query := func(p *Proxy, endpoint, accept string) *httptest.ResponseRecorder {
+		form := url.Values{"query": {"SELECT * {}"}}
+		req := httptest.NewRequest("POST", "/sparql-proxy?endpoint="+url.QueryEscape(endpoint), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		accept string
+		body   string
+		cache  string
+	}{
+		{"", DefaultAccept + ": SELECT * {}", "miss"},
+		{DefaultAccept, DefaultAccept + ": SELECT * {}", "hit"},
+		{"text/csv", "text/csv: SELECT * {}", "miss"},
+		{"text/csv", "text/csv: SELECT * {}", "hit"},
+		{"application/sparql-results+xml", "application/sparql-results+xml: SELECT * {}", "miss"},
+	}
+	for _, tt := range tests {
+		w := query(proxy, endpoint.URL, tt.accept)
+		if w.Code != http.StatusOK || w.Body.String() != tt.body || w.Header().Get("X-Ts-Cache") != tt.cache {
+			t.Errorf("Accept %q: %d %q (cache %s), want %q (cache %s)", tt.accept, w.Code, w.Body.String(), w.Header().Get("X-Ts-Cache"), tt.body, tt.cache)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("%d requests sent to the endpoint, want 3", requests)
+	}
+
+	if w := query(proxy, "http://other.example/sparql", ""); w.Code != http.StatusForbidden {
+		t.Errorf("query to an endpoint not listed: %d, want 403", w.Code)
+	}
+	if w := query(&Proxy{Endpoints: []string{"*"}}, endpoint.URL, ""); w.Code != http.StatusForbidden {
+		t.Errorf("query through a proxy listing *: %d, want 403", w.Code)
+	}
+
+	replay := &Proxy{Cache: &Cache{Dir: dir}, Replay: true}
+	if w := query(replay, endpoint.URL, "text/csv"); w.Code != http.StatusOK || w.Header().Get("X-Ts-Cache") != "hit" {
+		t.Errorf("replayed query: %d (cache %s), want a hit", w.Code, w.Header().Get("X-Ts-Cache"))
+	}
+	if w := query(replay, endpoint.URL, "text/tab-separated-values"); w.Code != http.StatusGatewayTimeout {
+		t.Errorf("replayed query of another media type: %d, want 504", w.Code)
+	}
+	if requests != 3 {
+		t.Errorf("replay sent queries to the endpoint")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/togostanza/ts/config"
+	"github.com/togostanza/ts/sparql"
+	"github.com/togostanza/ts/stanza"
+)
+
+const sparqlProxyPath = "/sparql-proxy"
+
+var (
+	flagSparqlEndpoints stringsFlag
+	flagSparqlCache     string
+	flagSparqlReplay    bool
+)
+
+func addSparqlProxyFlags(cmd *Command) {
+	cmd.Flag.Var(&flagSparqlEndpoints, "sparql-endpoint", "SPARQL endpoint queried through the proxy of the server (repeatable)")
+	cmd.Flag.StringVar(&flagSparqlCache, "sparql-cache", config.DefaultSparqlCache, "directory where the proxy keeps the responses, relative to the stanza base directory")
+	cmd.Flag.BoolVar(&flagSparqlReplay, "sparql-replay", false, "respond to queries only from the cache of the proxy, without accessing the endpoints")
+}
+
+// newSparqlProxy returns the SPARQL proxy as configured, and the options for
+// stanzas to use it. It returns nil if the proxy is disabled.
+func newSparqlProxy() (*sparql.Proxy, *stanza.SparqlProxy) {
+	endpoints := conf.SparqlProxy.Endpoints
+	proxied := endpoints
+	if len(endpoints) == 0 {
+		if !conf.SparqlProxy.Replay {
+			return nil, nil
+		}
+		// stanzas send queries to all endpoints to the proxy, which only replays them
+		proxied = []string{"*"}
+	}
+
+	proxy := &sparql.Proxy{
+		Endpoints: endpoints,
+		Cache:     &sparql.Cache{Dir: conf.SparqlCacheDir(flagStanzaBaseDir)},
+		Replay:    conf.SparqlProxy.Replay,
+		Client:    &http.Client{Timeout: time.Minute},
+	}
+	return proxy, &stanza.SparqlProxy{URL: sparqlProxyPath, Endpoints: proxied}
+}
@@ -0,0 +1,80 @@
+package stanza
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// HashedName returns name with a suffix derived from the content, e.g.
+// "assets/example.png" to "assets/example.0123abcd.png".
+func HashedName(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[:8]
+
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	return dir + strings.TrimSuffix(base, ext) + "." + hash + ext
+}
+
+// Integrity returns the Subresource Integrity hash of data.
+func Integrity(data []byte) string {
+	sum := sha512.Sum384(data)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// AssetName returns the name to refer the provider's asset, such as
+// "assets/css/ts.css", in the build.
+func (opts BuildOptions) AssetName(name string) string {
+	if hashed, ok := opts.AssetNames[name]; ok {
+		return hashed
+	}
+	return name
+}
+
+// HashedAssetNames maps the files in the assets directory, relative to the
+// stanza (e.g. "assets/example.png"), to their content-hashed names.
+func (st *Stanza) HashedAssetNames() (map[string]string, error) {
+	names := make(map[string]string)
+	if _, err := os.Stat(st.AssetsDir()); os.IsNotExist(err) {
+		return names, nil
+	}
+	err := filepath.Walk(st.AssetsDir(), func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(st.BaseDir, srcPath)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(srcPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		names[rel] = HashedName(rel, data)
+		return nil
+	})
+	return names, err
+}
+
+// rewriteAssetReferences replaces references to assets in src, HTML or
+// JavaScript, with the names in names.
+func rewriteAssetReferences(src string, names map[string]string) string {
+	return assetReferencePattern.ReplaceAllStringFunc(src, func(ref string) string {
+		m := assetReferencePattern.FindStringSubmatch(ref)
+		if hashed, ok := names["assets/"+path.Clean(m[2])]; ok {
+			return m[1] + hashed
+		}
+		return ref
+	})
+}
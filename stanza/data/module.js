@@ -0,0 +1,54 @@
+import initialize from '{{.StanzaJs}}';
+
+const descriptor = {{.DescriptorJson}};
+if (descriptor.sparqlProxy) {
+  descriptor.sparqlProxy.url = new URL(descriptor.sparqlProxy.url, import.meta.url).href;
+}
+if (descriptor.fixturesUrl) {
+  descriptor.fixturesUrl = new URL(descriptor.fixturesUrl, import.meta.url).href;
+}
+const headerHtml = {{.HeaderHtmlJson}};
+
+// Appends the contents of _header.html to the document. Scripts are recreated
+// so that they are executed, in order.
+function loadHeader(html) {
+  const template = document.createElement('template');
+  template.innerHTML = html;
+
+  return Array.from(template.content.childNodes).reduce((loaded, node) => loaded.then(() => new Promise((resolve) => {
+    if (node.nodeName !== 'SCRIPT') {
+      if (node.nodeType === Node.ELEMENT_NODE) {
+        document.head.appendChild(document.importNode(node, true));
+      }
+      resolve();
+      return;
+    }
+
+    const loadedScript = node.src && document.querySelector(`script[src="${node.getAttribute('src')}"]`);
+    if (loadedScript) {
+      // loaded by the page or another stanza
+      resolve(loadedScript.togostanzaLoaded);
+      return;
+    }
+
+    const script = document.createElement('script');
+    Array.from(node.attributes).forEach((attr) => script.setAttribute(attr.name, attr.value));
+    script.textContent = node.textContent;
+    if (node.src) {
+      script.togostanzaLoaded = new Promise((loaded) => {
+        script.addEventListener('load', loaded);
+        script.addEventListener('error', loaded);
+      });
+      script.togostanzaLoaded.then(resolve);
+    } else {
+      resolve();
+    }
+    document.head.appendChild(script);
+  })), Promise.resolve());
+}
+
+loadHeader(headerHtml).then(() => {
+  const Stanza = initialize(descriptor);
+
+  {{.IndexJs}}
+});
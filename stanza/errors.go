@@ -0,0 +1,149 @@
+package stanza
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// BuildError is an error occurred while loading or building a stanza.
+// File and Line point to the source of the error if known.
+type BuildError struct {
+	Stanza string
+	File   string
+	Line   int
+	Err    error
+}
+
+func (e *BuildError) Error() string {
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s: %s:%d: %s", e.Stanza, e.File, e.Line, e.Err)
+	case e.File != "":
+		return fmt.Sprintf("%s: %s: %s", e.Stanza, e.File, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Stanza, e.Err)
+}
+
+// SourceLine is a line of the file around the error.
+type SourceLine struct {
+	Number int
+	Text   string
+	Error  bool
+}
+
+// SourceContext returns the line of the error with n lines before and after it.
+func (e *BuildError) SourceContext(n int) ([]SourceLine, error) {
+	if e.File == "" || e.Line <= 0 {
+		return nil, nil
+	}
+	f, err := os.Open(e.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []SourceLine{}
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan() && i <= e.Line+n; i++ {
+		if i >= e.Line-n {
+			lines = append(lines, SourceLine{
+				Number: i,
+				Text:   scanner.Text(),
+				Error:  i == e.Line,
+			})
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// WriteHTML writes an HTML page describing the error, served in place of the
+// stanza's help.html built with opts.
+func (e *BuildError) WriteHTML(w io.Writer, opts BuildOptions) error {
+	tmpl := MustTemplateAsset("data/error.html")
+
+	lines, err := e.SourceContext(3)
+	if err != nil {
+		lines = nil
+	}
+
+	context := struct {
+		Stanza         string
+		File           string
+		Line           int
+		Message        string
+		Lines          []SourceLine
+		Stylesheet     string
+		BuildEventsURL string
+	}{
+		Stanza:         e.Stanza,
+		File:           e.File,
+		Line:           e.Line,
+		Message:        e.Err.Error(),
+		Lines:          lines,
+		Stylesheet:     "../" + opts.AssetName("assets/css/ts.css"),
+		BuildEventsURL: opts.BuildEventsURL,
+	}
+
+	return tmpl.Execute(w, context)
+}
+
+// WriteJSON writes the error as a JSON object.
+func (e *BuildError) WriteJSON(w io.Writer) error {
+	v := struct {
+		Stanza string `json:"stanza"`
+		File   string `json:"file,omitempty"`
+		Line   int    `json:"line,omitempty"`
+		Error  string `json:"error"`
+	}{
+		Stanza: e.Stanza,
+		File:   e.File,
+		Line:   e.Line,
+		Error:  e.Err.Error(),
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (st *Stanza) buildError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if be, ok := err.(*BuildError); ok {
+		return be
+	}
+	if pe, ok := err.(*os.PathError); ok {
+		return &BuildError{Stanza: st.Name, File: pe.Path, Err: pe.Err}
+	}
+	return &BuildError{Stanza: st.Name, Err: err}
+}
+
+// jsonError returns an error which points the location of err in the JSON file.
+func (st *Stanza) jsonError(file string, err error) error {
+	data, readErr := ioutil.ReadFile(file)
+	if readErr != nil {
+		return st.buildError(err)
+	}
+
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		if err != io.ErrUnexpectedEOF {
+			return st.buildError(err)
+		}
+		offset = int64(len(bytes.TrimRight(data, " \t\r\n")))
+	}
+
+	be := &BuildError{Stanza: st.Name, File: file, Err: err}
+	if offset <= int64(len(data)) {
+		be.Line = bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+	return be
+}
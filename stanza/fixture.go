@@ -0,0 +1,185 @@
+package stanza
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fixture is a response of a SPARQL endpoint recorded for a query of the
+// stanza, used instead of the endpoint in tests.
+type Fixture struct {
+	// Template is the name of the query template.
+	Template string `json:"template"`
+
+	// Parameters are the parameters the template is rendered with, as the
+	// stanza passes them to stanza.query().
+	Parameters map[string]interface{} `json:"parameters"`
+
+	Endpoint string `json:"endpoint"`
+
+	// Query is the query rendered when the fixture was recorded, for
+	// reference; fixtures are looked up by Template and Parameters.
+	Query string `json:"query"`
+
+	ContentType string    `json:"contentType"`
+	Body        string    `json:"body"`
+	Recorded    time.Time `json:"recorded"`
+}
+
+const fixturesDirName = "fixtures"
+
+func (st *Stanza) FixturesDir() string {
+	return path.Join(st.BaseDir, fixturesDirName)
+}
+
+// IsFixturesDir reports whether p is the fixtures directory of a stanza, that
+// is, a directory named fixtures next to metadata.json.
+func IsFixturesDir(p string) bool {
+	if filepath.Base(p) != fixturesDirName {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(filepath.Dir(p), "metadata.json"))
+	return err == nil && !info.IsDir()
+}
+
+// FixtureName returns the name of the fixture file of the query rendered from
+// the template with params.
+func FixtureName(template string, params map[string]interface{}) (string, error) {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	// json.Marshal sorts the keys of maps
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(template + "\x00" + string(data)))
+	return template + "-" + hex.EncodeToString(sum[:])[:16] + ".json", nil
+}
+
+// Fixture returns the fixture of the query rendered from the template with
+// params, or nil if it is not recorded.
+func (st *Stanza) Fixture(template string, params map[string]interface{}) (*Fixture, error) {
+	name, err := FixtureName(template, params)
+	if err != nil {
+		return nil, err
+	}
+	p := path.Join(st.FixturesDir(), name)
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, st.jsonError(p, err)
+	}
+	return &f, nil
+}
+
+// SaveFixture writes f into the fixtures directory, and returns the path of
+// the file.
+func (st *Stanza) SaveFixture(f *Fixture) (string, error) {
+	name, err := FixtureName(f.Template, f.Parameters)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(st.FixturesDir(), os.FileMode(0755)); err != nil {
+		return "", err
+	}
+	p := path.Join(st.FixturesDir(), name)
+	if err := ioutil.WriteFile(p, append(data, '\n'), os.FileMode(0644)); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// QueryTemplates returns the names of the templates other than HTML ones,
+// which are rendered into queries by stanza.query().
+func (st *Stanza) QueryTemplates() ([]string, error) {
+	paths, err := filepath.Glob(st.TemplateGlobPattern())
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, p := range paths {
+		if name := filepath.Base(p); !isHtmlTemplate(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RenderQuery renders the query template with params as stanza.query() does.
+func (st *Stanza) RenderQuery(template string, params map[string]interface{}) (string, error) {
+	p := path.Join(st.BaseDir, "templates", template)
+	text, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	query, err := renderHandlebars(string(text), params)
+	if err != nil {
+		return "", &BuildError{Stanza: st.Name, File: p, Err: err}
+	}
+	return query, nil
+}
+
+// ExampleParameters returns the parameters the stanza receives when the
+// examples are given as the attributes, converted to the types of the
+// parameters as the runtime does. The defaults are used for the parameters
+// without examples.
+func (meta *Metadata) ExampleParameters() (map[string]interface{}, error) {
+	params := make(map[string]interface{})
+	for _, p := range meta.Parameters {
+		v := p.Example
+		if isBlank(v) {
+			v = p.Default
+		}
+		if v == nil {
+			params[p.Key] = nil
+			continue
+		}
+		value, err := p.runtimeValue(valueString(v))
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %s", p.Key, err)
+		}
+		params[p.Key] = value
+	}
+	return params, nil
+}
+
+// runtimeValue converts the attribute value as coerce() of the runtime.
+func (p *Parameter) runtimeValue(attr string) (interface{}, error) {
+	switch p.ValueType() {
+	case ParameterTypeNumber:
+		s := strings.TrimSpace(attr)
+		if s == "" {
+			return float64(0), nil // Number("")
+		}
+		return strconv.ParseFloat(s, 64)
+	case ParameterTypeBoolean:
+		return attr != "false", nil
+	case ParameterTypeJSON:
+		var v interface{}
+		err := json.Unmarshal([]byte(attr), &v)
+		return v, err
+	}
+	return attr, nil
+}
@@ -0,0 +1,81 @@
+package stanza
+
+import (
+	"fmt"
+	"strings"
+)
+
+type openBlock struct {
+	name string
+	line int
+}
+
+// checkHandlebars finds unclosed mustaches and mismatched block helpers in a
+// Handlebars template. It returns the line of the first problem found.
+func checkHandlebars(text string) (int, error) {
+	stack := []openBlock{}
+	line := 1
+	pos := 0
+
+	for {
+		i := strings.Index(text[pos:], "{{")
+		if i < 0 {
+			break
+		}
+		start := pos + i
+		line += strings.Count(text[pos:start], "\n")
+		if start > 0 && text[start-1] == '\\' { // escaped mustache
+			pos = start + 2
+			continue
+		}
+
+		opening, closing := "{{", "}}"
+		switch {
+		case strings.HasPrefix(text[start:], "{{!--"):
+			opening, closing = "{{!--", "--}}"
+		case strings.HasPrefix(text[start:], "{{{"):
+			opening, closing = "{{{", "}}}"
+		}
+		j := strings.Index(text[start:], closing)
+		if j < 0 {
+			return line, fmt.Errorf("unclosed %q", opening)
+		}
+		end := start + j + len(closing)
+		tag := strings.Trim(text[start+2:end-2], "{}~ \t\r\n")
+
+		switch {
+		case strings.HasPrefix(tag, "#"):
+			stack = append(stack, openBlock{blockName(tag[1:]), line})
+		case strings.HasPrefix(tag, "^") && strings.TrimSpace(tag[1:]) != "":
+			stack = append(stack, openBlock{blockName(tag[1:]), line})
+		case strings.HasPrefix(tag, "/"):
+			name := blockName(tag[1:])
+			if len(stack) == 0 {
+				return line, fmt.Errorf("unexpected {{/%s}}", name)
+			}
+			top := stack[len(stack)-1]
+			if top.name != name {
+				return line, fmt.Errorf("{{/%s}} does not match {{#%s}} at line %d", name, top.name, top.line)
+			}
+			stack = stack[:len(stack)-1]
+		}
+
+		line += strings.Count(text[start:end], "\n")
+		pos = end
+	}
+
+	if len(stack) > 0 {
+		top := stack[len(stack)-1]
+		return top.line, fmt.Errorf("unclosed block {{#%s}}", top.name)
+	}
+	return 0, nil
+}
+
+func blockName(s string) string {
+	s = strings.TrimLeft(s, ">*")
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
@@ -0,0 +1,48 @@
+package stanza
+
+import (
+	"testing"
+)
+
+func TestCheckHandlebars(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		line int
+		err  string
+	}{
+		{"plain", "<p>hello</p>", 0, ""},
+		{"expressions", "<p>{{name}} {{{html}}} {{> partial}}</p>", 0, ""},
+		{"blocks", "{{#each items}}\n{{#if this}}{{this}}{{else}}-{{/if}}\n{{/each}}", 0, ""},
+		{"inverted block", "{{^items}}none{{/items}}", 0, ""},
+		{"else shorthand", "{{#if a}}x{{^}}y{{/if}}", 0, ""},
+		{"whitespace control", "{{~#if a~}} x {{~/if~}}", 0, ""},
+		{"partial block", "{{#> layout}}x{{/layout}}", 0, ""},
+		{"inline partial", "{{#*inline \"row\"}}x{{/inline}}", 0, ""},
+		{"comments", "{{! {{#if}} }}\n{{!-- {{#each}} }} --}}", 0, ""},
+		{"escaped mustache", "\\{{#if a}}", 0, ""},
+
+		{"unclosed mustache", "a\n{{name", 2, `unclosed "{{"`},
+		{"unclosed triple mustache", "{{{html}}", 1, `unclosed "{{{"`},
+		{"unclosed comment", "{{!-- a }}", 1, `unclosed "{{!--"`},
+		{"unclosed block", "{{#each items}}\n{{#if a}}\n{{/if}}", 1, "unclosed block {{#each}}"},
+		{"unexpected close", "a\n\n{{/if}}", 3, "unexpected {{/if}}"},
+		{"mismatched close", "{{#each items}}\n{{#if a}}\n{{/each}}", 3, "{{/each}} does not match {{#if}} at line 2"},
+		{"lines after multiline tags", "{{#if\n  a}}\n{{/each}}", 3, "{{/each}} does not match {{#if}} at line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, err := checkHandlebars(tt.text)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("checkHandlebars(%q) = %d, %v; want no error", tt.text, line, err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err || line != tt.line {
+				t.Errorf("checkHandlebars(%q) = %d, %v; want %d, %s", tt.text, line, err, tt.line, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package stanza
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a single problem found in a stanza's files.
+type Diagnostic struct {
+	File     string   `json:"file"`
+	Pointer  string   `json:"pointer"` // JSON pointer (RFC 6901) into File
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%s: %s: %s", d.File, d.Pointer, d.Severity, d.Message)
+}
+
+type valueKind int
+
+const (
+	kindAny valueKind = iota
+	kindString
+	kindBool
+	kindObject
+	kindArray
+)
+
+func (k valueKind) String() string {
+	switch k {
+	case kindString:
+		return "string"
+	case kindBool:
+		return "boolean"
+	case kindObject:
+		return "object"
+	case kindArray:
+		return "array"
+	}
+	return "any"
+}
+
+func (k valueKind) match(v interface{}) bool {
+	switch k {
+	case kindString:
+		_, ok := v.(string)
+		return ok
+	case kindBool:
+		_, ok := v.(bool)
+		return ok
+	case kindObject:
+		_, ok := v.(map[string]interface{})
+		return ok
+	case kindArray:
+		_, ok := v.([]interface{})
+		return ok
+	}
+	return true
+}
+
+type property struct {
+	kind     valueKind
+	required bool
+}
+
+var metadataSchema = map[string]property{
+	"@context":           {kindObject, true},
+	"@id":                {kindString, true},
+	"stanza:label":       {kindString, true},
+	"stanza:definition":  {kindString, true},
+	"stanza:parameter":   {kindArray, true},
+	"stanza:usage":       {kindString, false}, // generated if missing or empty
+	"stanza:type":        {kindString, false},
+	"stanza:context":     {kindString, false},
+	"stanza:display":     {kindString, false},
+	"stanza:provider":    {kindString, false},
+	"stanza:license":     {kindString, false},
+	"stanza:author":      {kindString, false},
+	"stanza:address":     {kindString, false},
+	"stanza:contributor": {kindArray, false},
+	"stanza:created":     {kindString, false},
+	"stanza:updated":     {kindString, false},
+}
+
+var parameterSchema = map[string]property{
+	"stanza:key":         {kindString, true},
+	"stanza:description": {kindString, false},
+	"stanza:example":     {kindAny, false},
+	"stanza:required":    {kindBool, false},
+	"stanza:type":        {kindString, false},
+	"stanza:choice":      {kindArray, false},
+	"stanza:default":     {kindAny, false},
+	"stanza:pattern":     {kindString, false},
+}
+
+const dateLayout = "2006-01-02"
+
+type linter struct {
+	file        string
+	diagnostics []Diagnostic
+}
+
+func (l *linter) report(pointer string, severity Severity, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{
+		File:     l.file,
+		Pointer:  pointer,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) checkObject(pointer string, obj map[string]interface{}, schema map[string]property) {
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		prop := schema[key]
+		v, ok := obj[key]
+		if !ok {
+			if prop.required {
+				l.report(pointer, SeverityError, "missing required property %q", key)
+			}
+			continue
+		}
+		if !prop.kind.match(v) {
+			l.report(pointer+"/"+escapePointerToken(key), SeverityError, "%q must be %s", key, prop.kind)
+		}
+	}
+
+	unknown := []string{}
+	for key := range obj {
+		if _, ok := schema[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		l.report(pointer+"/"+escapePointerToken(key), SeverityWarning, "unknown property %q", key)
+	}
+}
+
+func escapePointerToken(token string) string {
+	token = strings.Replace(token, "~", "~0", -1)
+	return strings.Replace(token, "/", "~1", -1)
+}
+
+// Lint checks the stanza's metadata.json and returns the problems found.
+func (st *Stanza) Lint() []Diagnostic {
+	l := &linter{file: st.MetadataPath()}
+
+	f, err := os.Open(st.MetadataPath())
+	if err != nil {
+		l.report("", SeverityError, "%s", err)
+		return l.diagnostics
+	}
+	defer f.Close()
+
+	var raw interface{}
+	if err := json.NewDecoder(f).Decode(&raw); err != nil {
+		l.report("", SeverityError, "invalid JSON: %s", err)
+		return l.diagnostics
+	}
+
+	root, ok := raw.(map[string]interface{})
+	if !ok {
+		l.report("", SeverityError, "metadata must be an object")
+		return l.diagnostics
+	}
+	l.checkObject("", root, metadataSchema)
+
+	if id, ok := root["@id"].(string); ok && id != st.Name {
+		l.report("/@id", SeverityError, "@id %q does not match the stanza directory name %q", id, st.Name)
+	}
+
+	if usage, ok := root["stanza:usage"].(string); ok && strings.TrimSpace(usage) != "" {
+		if !strings.Contains(usage, "<"+st.ElementName()) {
+			l.report("/stanza:usage", SeverityError, "usage does not use <%s>", st.ElementName())
+		} else if err := st.decodeMetadata(root); err == nil {
+			for _, problem := range st.UsageProblems() {
+				l.report("/stanza:usage", SeverityWarning, "%s", problem)
+			}
+		}
+	}
+
+	for _, key := range []string{"stanza:created", "stanza:updated"} {
+		if s, ok := root[key].(string); ok {
+			if _, err := time.Parse(dateLayout, s); err != nil {
+				l.report("/"+key, SeverityError, "invalid date %q (expected YYYY-MM-DD)", s)
+			}
+		}
+	}
+
+	if contributors, ok := root["stanza:contributor"].([]interface{}); ok {
+		for i, c := range contributors {
+			if _, ok := c.(string); !ok {
+				l.report(fmt.Sprintf("/stanza:contributor/%d", i), SeverityError, "contributor must be string")
+			}
+		}
+	}
+
+	if parameters, ok := root["stanza:parameter"].([]interface{}); ok {
+		l.checkParameters(parameters)
+	}
+
+	return l.diagnostics
+}
+
+func (l *linter) checkParameters(parameters []interface{}) {
+	seen := make(map[string]int)
+	for i, p := range parameters {
+		pointer := fmt.Sprintf("/stanza:parameter/%d", i)
+		param, ok := p.(map[string]interface{})
+		if !ok {
+			l.report(pointer, SeverityError, "parameter must be object")
+			continue
+		}
+		l.checkObject(pointer, param, parameterSchema)
+
+		key, ok := param["stanza:key"].(string)
+		if !ok {
+			continue
+		}
+		if key == "" {
+			l.report(pointer+"/stanza:key", SeverityError, "parameter key must not be empty")
+			continue
+		}
+		if j, ok := seen[key]; ok {
+			l.report(pointer+"/stanza:key", SeverityError, "duplicate parameter key %q (also defined at /stanza:parameter/%d)", key, j)
+		} else {
+			seen[key] = i
+		}
+
+		l.checkParameterValues(pointer, param)
+
+		if required, _ := param["stanza:required"].(bool); required {
+			if example, ok := param["stanza:example"]; !ok || example == nil || example == "" {
+				l.report(pointer, SeverityWarning, "required parameter %q has no example", key)
+			}
+		}
+	}
+}
+
+// decodeMetadata sets st.Metadata from the decoded metadata.json.
+func (st *Stanza) decodeMetadata(raw map[string]interface{}) error {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &st.Metadata)
+}
+
+// checkParameterValues checks the type of the parameter and its example and default.
+func (l *linter) checkParameterValues(pointer string, raw map[string]interface{}) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return
+	}
+	var param Parameter
+	if err := json.Unmarshal(data, &param); err != nil {
+		// type mismatches of the properties are reported by checkObject
+		return
+	}
+
+	if err := param.Check(); err != nil {
+		l.report(pointer, SeverityError, "parameter %q: %s", param.Key, err)
+		return
+	}
+	if !isBlank(param.Example) {
+		if err := param.Validate(param.Example); err != nil {
+			l.report(pointer+"/stanza:example", SeverityError, "invalid example: %s", err)
+		}
+	}
+	if !isBlank(param.Default) {
+		if err := param.Validate(param.Default); err != nil {
+			l.report(pointer+"/stanza:default", SeverityError, "invalid default: %s", err)
+		}
+	}
+}
+
+// HasErrors reports whether any of diagnostics is an error.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package stanza
+
+import (
+	"testing"
+)
+
+func TestLintWithoutUsage(t *testing.T) {
+	st, err := NewStanza("testdata/nousage", "nousage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range st.Lint() {
+		t.Errorf("Lint(): %s", d)
+	}
+}
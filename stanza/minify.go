@@ -0,0 +1,294 @@
+package stanza
+
+import (
+	"strings"
+)
+
+// minifyJs removes comments and redundant whitespace from JavaScript.
+// It is conservative: line breaks which may be significant for automatic
+// semicolon insertion are kept.
+func minifyJs(src string) string {
+	m := &jsMinifier{src: src}
+	m.run()
+	return strings.TrimSpace(m.out.String())
+}
+
+type jsMinifier struct {
+	src string
+	pos int
+	out strings.Builder
+
+	// kinds of the nesting contexts; true for a template literal, false for a brace
+	stack []bool
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c == '\\' || c >= 0x80 ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func (m *jsMinifier) last() byte {
+	s := m.out.String()
+	if len(s) == 0 {
+		return 0
+	}
+	return s[len(s)-1]
+}
+
+// lastWord returns the identifier just written, if any.
+func (m *jsMinifier) lastWord() string {
+	s := strings.TrimRight(m.out.String(), "\n")
+	i := len(s)
+	for i > 0 && isIdentChar(s[i-1]) {
+		i--
+	}
+	return s[i:]
+}
+
+func (m *jsMinifier) regexpAllowed() bool {
+	switch m.lastWord() {
+	case "":
+	case "return", "typeof", "instanceof", "case", "do", "else", "in", "of", "new", "delete", "void", "throw", "yield", "await":
+		return true
+	default:
+		return false
+	}
+	s := strings.TrimRight(m.out.String(), "\n")
+	if len(s) == 0 {
+		return true
+	}
+	return strings.IndexByte("(,=:[!&|?{};+-*%<>~^", s[len(s)-1]) >= 0
+}
+
+func (m *jsMinifier) run() {
+	for m.pos < len(m.src) {
+		c := m.src[m.pos]
+		switch {
+		case isSpace(c) || strings.HasPrefix(m.src[m.pos:], "//") || strings.HasPrefix(m.src[m.pos:], "/*"):
+			m.whitespace()
+		case c == '\'' || c == '"':
+			m.quoted(c)
+		case c == '`':
+			m.out.WriteByte(c)
+			m.pos++
+			m.templateLiteral()
+		case c == '/' && m.regexpAllowed():
+			m.regexp()
+		case c == '{':
+			m.stack = append(m.stack, false)
+			m.out.WriteByte(c)
+			m.pos++
+		case c == '}':
+			if n := len(m.stack); n > 0 {
+				inTemplate := m.stack[n-1]
+				m.stack = m.stack[:n-1]
+				m.out.WriteByte(c)
+				m.pos++
+				if inTemplate {
+					m.templateLiteral()
+				}
+			} else {
+				m.out.WriteByte(c)
+				m.pos++
+			}
+		default:
+			m.out.WriteByte(c)
+			m.pos++
+		}
+	}
+}
+
+// whitespace consumes whitespace and comments, and writes a separator if needed.
+func (m *jsMinifier) whitespace() {
+	newline := false
+	for m.pos < len(m.src) {
+		rest := m.src[m.pos:]
+		if isSpace(rest[0]) {
+			if rest[0] == '\n' || rest[0] == '\r' {
+				newline = true
+			}
+			m.pos++
+		} else if strings.HasPrefix(rest, "//") {
+			end := strings.IndexAny(rest, "\n\r")
+			if end < 0 {
+				end = len(rest)
+			}
+			m.pos += end
+		} else if strings.HasPrefix(rest, "/*") {
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 4
+			}
+			if strings.ContainsAny(rest[:end], "\n\r") {
+				newline = true
+			}
+			m.pos += end
+		} else {
+			break
+		}
+	}
+
+	prev := m.last()
+	if prev == 0 || m.pos >= len(m.src) {
+		return
+	}
+	next := m.src[m.pos]
+
+	if newline {
+		if strings.IndexByte("{([,;\n", prev) >= 0 || strings.IndexByte(")]},;", next) >= 0 {
+			return
+		}
+		m.out.WriteByte('\n')
+		return
+	}
+	if isIdentChar(prev) && isIdentChar(next) || prev == next && (prev == '+' || prev == '-') {
+		m.out.WriteByte(' ')
+	}
+}
+
+func (m *jsMinifier) quoted(quote byte) {
+	start := m.pos
+	m.pos++
+	for m.pos < len(m.src) {
+		c := m.src[m.pos]
+		m.pos++
+		if c == '\\' {
+			m.pos++
+		} else if c == quote || c == '\n' {
+			break
+		}
+	}
+	if m.pos > len(m.src) {
+		m.pos = len(m.src)
+	}
+	m.out.WriteString(m.src[start:m.pos])
+}
+
+// templateLiteral copies the rest of a template literal up to the closing
+// backtick or the beginning of a substitution.
+func (m *jsMinifier) templateLiteral() {
+	start := m.pos
+	for m.pos < len(m.src) {
+		c := m.src[m.pos]
+		if c == '\\' {
+			m.pos += 2
+			continue
+		}
+		if c == '`' {
+			m.pos++
+			break
+		}
+		if c == '$' && strings.HasPrefix(m.src[m.pos:], "${") {
+			m.pos += 2
+			m.stack = append(m.stack, true)
+			break
+		}
+		m.pos++
+	}
+	if m.pos > len(m.src) {
+		m.pos = len(m.src)
+	}
+	m.out.WriteString(m.src[start:m.pos])
+}
+
+func (m *jsMinifier) regexp() {
+	start := m.pos
+	m.pos++
+	inClass := false
+	for m.pos < len(m.src) {
+		c := m.src[m.pos]
+		m.pos++
+		switch {
+		case c == '\\':
+			m.pos++
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '/' && !inClass, c == '\n':
+			m.out.WriteString(m.src[start:m.pos])
+			return
+		}
+	}
+	if m.pos > len(m.src) {
+		m.pos = len(m.src)
+	}
+	m.out.WriteString(m.src[start:m.pos])
+}
+
+// collapseHtml collapses runs of whitespace in an HTML template into a single
+// space and removes HTML comments. Contents of pre, textarea, script and style
+// elements and of Handlebars expressions are kept as is.
+func collapseHtml(src string) string {
+	var out strings.Builder
+	pos := 0
+	for pos < len(src) {
+		rest := src[pos:]
+		switch {
+		case strings.HasPrefix(rest, "{{"):
+			end := strings.Index(rest, "}}")
+			if end < 0 {
+				out.WriteString(rest)
+				return out.String()
+			}
+			end += 2
+			for end < len(rest) && rest[end] == '}' {
+				end++
+			}
+			out.WriteString(rest[:end])
+			pos += end
+		case strings.HasPrefix(rest, "<!--") && !strings.HasPrefix(rest, "<!--["):
+			end := strings.Index(rest, "-->")
+			if end < 0 {
+				return out.String()
+			}
+			pos += end + 3
+		case rest[0] == '<':
+			if tag := rawTextTag(rest); tag != "" {
+				end := strings.Index(strings.ToLower(rest), "</"+tag)
+				if end < 0 {
+					end = len(rest)
+				}
+				out.WriteString(rest[:end])
+				pos += end
+				if end < len(rest) {
+					out.WriteString(rest[end : end+len(tag)+2])
+					pos += len(tag) + 2
+				}
+				continue
+			}
+			out.WriteByte('<')
+			pos++
+		case isSpace(rest[0]):
+			for pos < len(src) && isSpace(src[pos]) {
+				pos++
+			}
+			if s := out.String(); len(s) == 0 || s[len(s)-1] != ' ' {
+				out.WriteByte(' ')
+			}
+		default:
+			out.WriteByte(rest[0])
+			pos++
+		}
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// rawTextTag returns the name of the element starting at s if its contents
+// must not be collapsed.
+func rawTextTag(s string) string {
+	lower := strings.ToLower(s)
+	for _, tag := range []string{"pre", "textarea", "script", "style"} {
+		// custom elements such as pre-view are not raw text elements
+		if strings.HasPrefix(lower, "<"+tag) && len(s) > len(tag)+1 && !isIdentChar(s[len(tag)+1]) && s[len(tag)+1] != '-' {
+			return tag
+		}
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package stanza
+
+import (
+	"testing"
+)
+
+func TestMinifyJs(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"whitespace",
+			"function  f ( a,  b )  {\n  return a  +  b;\n}\n",
+			"function f(a,b){return a+b;}",
+		},
+		{
+			"comments",
+			"// header\nconst a = 1; /* inline */ const b = 2;\n/*\n * block\n */\nf(a, b); // trailing",
+			"const a=1;const b=2;f(a,b);",
+		},
+		{
+			"line breaks for automatic semicolon insertion",
+			"const a = 1\nconst b = a\n++c\nreturn\nx",
+			"const a=1\nconst b=a\n++c\nreturn\nx",
+		},
+		{
+			"unary operators",
+			"a - -b; c + +d; e - f",
+			"a- -b;c+ +d;e-f",
+		},
+		{
+			"strings",
+			"f('a  //  b', \"c /* d */ e\", 'it\\'s  ok')",
+			"f('a  //  b',\"c /* d */ e\",'it\\'s  ok')",
+		},
+		{
+			"regexp literals",
+			"const re = /a  b\\/\\/ c/g; s.replace(/[/]  x/, '');",
+			"const re=/a  b\\/\\/ c/g;s.replace(/[/]  x/,'');",
+		},
+		{
+			"regexp literals after keywords",
+			"function f(s) { return /  x/.test(s) }",
+			"function f(s){return/  x/.test(s)}",
+		},
+		{
+			"regexp literals after arrows",
+			"s.filter(x => /a b/.test(x))",
+			"s.filter(x=>/a b/.test(x))",
+		},
+		{
+			"division",
+			"const x = a / b / c; const y = (a + b) / 2; const z = arr[0] / 2",
+			"const x=a/b/c;const y=(a+b)/2;const z=arr[0]/2",
+		},
+		{
+			"template literals",
+			"const s = `a  //  b\n  c /* d */`;",
+			"const s=`a  //  b\n  c /* d */`;",
+		},
+		{
+			"template literal substitutions",
+			"const s = `a  ${ x + y }  b ${ { k: 1 }.k }  c`;",
+			"const s=`a  ${x+y}  b ${{k:1}.k}  c`;",
+		},
+		{
+			"nested template literals",
+			"f(`a ${ g(`b  ${ c }  d`) }  e`)",
+			"f(`a ${g(`b  ${c}  d`)}  e`)",
+		},
+		{
+			"escapes in template literals",
+			"const s = `\\`  ${ '\\${' }  \\${x}`",
+			"const s=`\\`  ${'\\${'}  \\${x}`",
+		},
+		{
+			"unterminated comment",
+			"a; /* b",
+			"a;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := minifyJs(tt.src); got != tt.want {
+				t.Errorf("minifyJs(%q)\n got %q\nwant %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollapseHtml(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"whitespace",
+			"  <div>\n    <p>a   b</p>\n\t<p>c</p>\n  </div>\n",
+			"<div> <p>a b</p> <p>c</p> </div>",
+		},
+		{
+			"comments",
+			"<p>a</p>\n<!-- note\n  -->\n<p>b</p>",
+			"<p>a</p> <p>b</p>",
+		},
+		{
+			"conditional comments",
+			"<!--[if IE]>  x  <![endif]-->",
+			"<!--[if IE]> x <![endif]-->",
+		},
+		{
+			"pre",
+			"<div>\n  <pre>\n  a\n    b\n  </pre>\n</div>",
+			"<div> <pre>\n  a\n    b\n  </pre> </div>",
+		},
+		{
+			"pre with attributes and upper case",
+			"<PRE class=\"x\">  a  </PRE>  <p>  b  </p>",
+			"<PRE class=\"x\">  a  </PRE> <p> b </p>",
+		},
+		{
+			"textarea, script and style",
+			"<textarea>  a  </textarea>\n<script>\n  if (a  <  b) {}\n</script>\n<style>\n  p  { }\n</style>",
+			"<textarea>  a  </textarea> <script>\n  if (a  <  b) {}\n</script> <style>\n  p  { }\n</style>",
+		},
+		{
+			"elements whose names begin with those of raw text elements",
+			"<pre-view>  a  </pre-view>\n<presentation>  b  </presentation>\n<pre>  c  </pre>",
+			"<pre-view> a </pre-view> <presentation> b </presentation> <pre>  c  </pre>",
+		},
+		{
+			"Handlebars expressions",
+			"<p>{{  name  }}</p>\n{{#each  items}}\n  <li>{{{  html  }}}</li>\n{{/each}}",
+			"<p>{{  name  }}</p> {{#each  items}} <li>{{{  html  }}}</li> {{/each}}",
+		},
+		{
+			"unterminated pre",
+			"<pre>  a\n  b",
+			"<pre>  a\n  b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collapseHtml(tt.src); got != tt.want {
+				t.Errorf("collapseHtml(%q)\n got %q\nwant %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package stanza
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// Types of parameters. A parameter without type is a string.
+const (
+	ParameterTypeString  = "string"
+	ParameterTypeNumber  = "number"
+	ParameterTypeBoolean = "boolean"
+	ParameterTypeEnum    = "enum"
+	ParameterTypeURL     = "url"
+	ParameterTypeJSON    = "json"
+)
+
+var parameterTypes = []string{
+	ParameterTypeString,
+	ParameterTypeNumber,
+	ParameterTypeBoolean,
+	ParameterTypeEnum,
+	ParameterTypeURL,
+	ParameterTypeJSON,
+}
+
+type Parameter struct {
+	Key         string        `json:"stanza:key"`
+	Description string        `json:"stanza:description"`
+	Example     interface{}   `json:"stanza:example"`
+	Required    bool          `json:"stanza:required"`
+	Type        string        `json:"stanza:type"`
+	Choices     []interface{} `json:"stanza:choice"`
+	Default     interface{}   `json:"stanza:default"`
+	Pattern     string        `json:"stanza:pattern"`
+}
+
+// ValueType returns the type of the parameter, defaulting to string.
+func (p *Parameter) ValueType() string {
+	if p.Type == "" {
+		return ParameterTypeString
+	}
+	return p.Type
+}
+
+// Check validates the definition of the parameter itself.
+func (p *Parameter) Check() error {
+	known := false
+	for _, t := range parameterTypes {
+		if p.ValueType() == t {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown type %q", p.Type)
+	}
+	if p.ValueType() == ParameterTypeEnum && len(p.Choices) == 0 {
+		return fmt.Errorf("enum parameter has no choices")
+	}
+	if p.Pattern != "" {
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+	}
+	return nil
+}
+
+// Validate checks v against the type, choices and pattern of the parameter.
+// v may be either a JSON value or the string given as an attribute.
+func (p *Parameter) Validate(v interface{}) error {
+	s, isString := v.(string)
+	if !isString {
+		if p.ValueType() != ParameterTypeJSON {
+			s = valueString(v)
+		}
+	}
+
+	switch p.ValueType() {
+	case ParameterTypeNumber:
+		if _, ok := v.(float64); !ok {
+			if _, err := strconv.ParseFloat(s, 64); !isString || err != nil {
+				return fmt.Errorf("%s is not a number", describeValue(v))
+			}
+		}
+	case ParameterTypeBoolean:
+		if _, ok := v.(bool); !ok && s != "true" && s != "false" {
+			return fmt.Errorf("%s is not a boolean", describeValue(v))
+		}
+	case ParameterTypeEnum:
+		found := false
+		for _, choice := range p.Choices {
+			if valueString(choice) == s {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s is not one of the choices", describeValue(v))
+		}
+	case ParameterTypeURL:
+		u, err := url.Parse(s)
+		if !isString || err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s is not an absolute URL", describeValue(v))
+		}
+	case ParameterTypeJSON:
+		if isString {
+			var parsed interface{}
+			if err := json.Unmarshal([]byte(s), &parsed); err != nil {
+				return fmt.Errorf("%q is not valid JSON: %s", s, err)
+			}
+		}
+		return nil
+	case ParameterTypeString:
+		// values of untyped parameters are taken as strings, as the runtime does
+		if !isString && p.Type == ParameterTypeString {
+			return fmt.Errorf("%s is not a string", describeValue(v))
+		}
+	}
+
+	if p.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(s) {
+			return fmt.Errorf("%q does not match the pattern %q", s, p.Pattern)
+		}
+	}
+	return nil
+}
+
+// valueString returns v as it would be written in an attribute.
+func valueString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// describeValue formats v for error messages.
+func describeValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return valueString(v)
+}
+
+// isBlank reports whether the value is not given in metadata.
+func isBlank(v interface{}) bool {
+	return v == nil || v == ""
+}
+
+// CheckParameters validates the definitions of the parameters and their
+// examples and defaults.
+func (meta *Metadata) CheckParameters() error {
+	for _, p := range meta.Parameters {
+		if err := p.Check(); err != nil {
+			return fmt.Errorf("parameter %q: %s", p.Key, err)
+		}
+		if !isBlank(p.Example) {
+			if err := p.Validate(p.Example); err != nil {
+				return fmt.Errorf("parameter %q: example: %s", p.Key, err)
+			}
+		}
+		if !isBlank(p.Default) {
+			if err := p.Validate(p.Default); err != nil {
+				return fmt.Errorf("parameter %q: default: %s", p.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// descriptorParameter is the definition of a parameter passed to the runtime.
+type descriptorParameter struct {
+	Key      string        `json:"key"`
+	Type     string        `json:"type"`
+	Required bool          `json:"required,omitempty"`
+	Default  interface{}   `json:"default,omitempty"`
+	Choices  []interface{} `json:"choices,omitempty"`
+	Pattern  string        `json:"pattern,omitempty"`
+}
+
+func (meta *Metadata) descriptorParameters() []descriptorParameter {
+	params := make([]descriptorParameter, len(meta.Parameters))
+	for i, p := range meta.Parameters {
+		params[i] = descriptorParameter{
+			Key:      p.Key,
+			Type:     p.ValueType(),
+			Required: p.Required,
+			Default:  p.Default,
+			Choices:  p.Choices,
+			Pattern:  p.Pattern,
+		}
+	}
+	return params
+}
+
+// helpParameter is a parameter as shown in the help page.
+type helpParameter struct {
+	Parameter
+	Choices    []string
+	Value      string
+	HasDefault bool
+	DefaultStr string
+}
+
+// Checked reports whether the checkbox of a boolean parameter is checked.
+func (p helpParameter) Checked() bool {
+	return p.Value == "true"
+}
+
+func (meta *Metadata) helpParameters() []helpParameter {
+	params := make([]helpParameter, len(meta.Parameters))
+	for i, p := range meta.Parameters {
+		hp := helpParameter{
+			Parameter:  p,
+			HasDefault: !isBlank(p.Default),
+			DefaultStr: valueString(p.Default),
+		}
+		for _, choice := range p.Choices {
+			hp.Choices = append(hp.Choices, valueString(choice))
+		}
+		if !isBlank(p.Example) {
+			hp.Value = valueString(p.Example)
+		} else {
+			hp.Value = hp.DefaultStr
+		}
+		params[i] = hp
+	}
+	return params
+}
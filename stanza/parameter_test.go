@@ -0,0 +1,66 @@
+package stanza
+
+import (
+	"testing"
+)
+
+func TestParameterValidate(t *testing.T) {
+	tests := []struct {
+		param Parameter
+		value interface{}
+		ok    bool
+	}{
+		// untyped parameters take any value as a string, as the runtime does
+		{Parameter{}, "foo", true},
+		{Parameter{}, 10.0, true},
+		{Parameter{}, true, true},
+		{Parameter{Pattern: `\d+`}, 9606.0, true},
+		{Parameter{Pattern: `\d+`}, 1.5, false},
+
+		{Parameter{Type: "string"}, "foo", true},
+		{Parameter{Type: "string"}, 10.0, false},
+
+		{Parameter{Type: "number"}, 10.0, true},
+		{Parameter{Type: "number"}, "10", true},
+		{Parameter{Type: "number"}, "ten", false},
+		{Parameter{Type: "number"}, true, false},
+
+		{Parameter{Type: "boolean"}, true, true},
+		{Parameter{Type: "boolean"}, "false", true},
+		{Parameter{Type: "boolean"}, "yes", false},
+
+		{Parameter{Type: "enum", Choices: []interface{}{"a", 1.0}}, "a", true},
+		{Parameter{Type: "enum", Choices: []interface{}{"a", 1.0}}, "1", true},
+		{Parameter{Type: "enum", Choices: []interface{}{"a", 1.0}}, "b", false},
+
+		{Parameter{Type: "url"}, "https://example.org/sparql", true},
+		{Parameter{Type: "url"}, "/sparql", false},
+
+		{Parameter{Type: "json"}, `{"a": 1}`, true},
+		{Parameter{Type: "json"}, []interface{}{1.0}, true},
+		{Parameter{Type: "json"}, `{a: 1}`, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.param.Validate(tt.value)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("type %q, pattern %q: Validate(%#v) = %v, want ok = %v", tt.param.Type, tt.param.Pattern, tt.value, err, tt.ok)
+		}
+	}
+}
+
+func TestLegacyMetadata(t *testing.T) {
+	st, err := NewStanza("testdata/legacy", "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := st.Metadata.CheckParameters(); err != nil {
+		t.Errorf("CheckParameters() = %v", err)
+	}
+	for _, d := range st.Lint() {
+		if d.Severity == SeverityError {
+			t.Errorf("Lint(): %s", d)
+		}
+	}
+}
@@ -0,0 +1,520 @@
+package stanza
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// renderHandlebars renders a Handlebars template without HTML escaping, as
+// stanza.query() renders query templates. Only a subset of Handlebars is
+// supported: expressions of paths, comments, whitespace control and the
+// built-in if, unless, each and with helpers. {{#each}} iterates the keys of
+// an object in sorted order, as the order of the keys in JSON is not kept.
+func renderHandlebars(text string, data interface{}) (string, error) {
+	tokens, err := tokenizeHandlebars(text)
+	if err != nil {
+		return "", err
+	}
+	stripHandlebarsWhitespace(tokens)
+
+	pos := 0
+	nodes, err := parseHandlebars(tokens, &pos, "")
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := renderNodes(&b, nodes, []hbFrame{{value: data}}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// hbToken is a piece of a Handlebars template. Tokens alternate between text
+// and mustaches, starting and ending with text.
+type hbToken struct {
+	text string // the text, or the content of the mustache without braces
+	tag  bool
+	line int
+
+	stripBefore, stripAfter bool // "~" of the mustache
+}
+
+// standalone reports whether the mustache is removed with its line if it is
+// alone on the line: comments, blocks and else.
+func (t *hbToken) standalone() bool {
+	return t.tag && (t.text == "else" || t.text == "^" || strings.ContainsAny(t.text[:1], "!#^/"))
+}
+
+func tokenizeHandlebars(text string) ([]*hbToken, error) {
+	tokens := []*hbToken{}
+	line := 1
+	pos := 0
+	var literal strings.Builder
+
+	for {
+		i := strings.Index(text[pos:], "{{")
+		if i < 0 {
+			literal.WriteString(text[pos:])
+			break
+		}
+		start := pos + i
+		if start > 0 && text[start-1] == '\\' { // escaped mustache
+			literal.WriteString(text[pos:start-1] + "{{")
+			line += strings.Count(text[pos:start], "\n")
+			pos = start + 2
+			continue
+		}
+		literal.WriteString(text[pos:start])
+		line += strings.Count(text[pos:start], "\n")
+
+		rest := strings.TrimPrefix(text[start+2:], "~")
+		closing := "}}"
+		switch {
+		case strings.HasPrefix(rest, "!--"):
+			closing = "--}}"
+			j1, j2 := strings.Index(text[start:], "--}}"), strings.Index(text[start:], "--~}}")
+			if j2 >= 0 && (j1 < 0 || j2 < j1) {
+				closing = "--~}}"
+			}
+		case strings.HasPrefix(rest, "{"):
+			closing = "}}}"
+		}
+		j := strings.Index(text[start:], closing)
+		if j < 0 {
+			return nil, fmt.Errorf("line %d: unclosed %q", line, text[start:start+2])
+		}
+		end := start + j + len(closing)
+
+		t := &hbToken{tag: true, line: line}
+		inner := text[start+2 : end-2]
+		if strings.HasPrefix(inner, "~") {
+			t.stripBefore = true
+			inner = inner[1:]
+		}
+		if strings.HasSuffix(inner, "~") {
+			t.stripAfter = true
+			inner = inner[:len(inner)-1]
+		}
+		if closing == "}}}" {
+			inner = strings.TrimSuffix(strings.TrimPrefix(inner, "{"), "}")
+		}
+		t.text = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(inner), "&"))
+		if t.text == "" {
+			return nil, fmt.Errorf("line %d: empty mustache", line)
+		}
+
+		tokens = append(tokens, &hbToken{text: literal.String()}, t)
+		literal.Reset()
+		line += strings.Count(text[start:end], "\n")
+		pos = end
+	}
+	return append(tokens, &hbToken{text: literal.String()}), nil
+}
+
+func isInlineSpace(s string) bool {
+	return strings.Trim(s, " \t\r") == ""
+}
+
+// stripHandlebarsWhitespace removes the lines of standalone mustaches and the
+// whitespace around mustaches with "~", as Handlebars does.
+func stripHandlebarsWhitespace(tokens []*hbToken) {
+	// the ranges of the text tokens to keep; standalone lines are determined
+	// by the original text
+	starts := make([]int, len(tokens))
+	ends := make([]int, len(tokens))
+	for i, t := range tokens {
+		ends[i] = len(t.text)
+	}
+
+	for i := 1; i < len(tokens); i += 2 {
+		if !tokens[i].standalone() {
+			continue
+		}
+		prev, next := tokens[i-1].text, tokens[i+1].text
+
+		prevCut := strings.LastIndex(prev, "\n") + 1
+		if prevCut == 0 && i-1 != 0 {
+			continue // another mustache on the line
+		}
+		if !isInlineSpace(prev[prevCut:]) {
+			continue
+		}
+		nextCut := strings.Index(next, "\n") + 1
+		if nextCut == 0 {
+			if i+1 != len(tokens)-1 {
+				continue
+			}
+			nextCut = len(next)
+		}
+		if !isInlineSpace(strings.TrimSuffix(next[:nextCut], "\n")) {
+			continue
+		}
+
+		if prevCut < ends[i-1] {
+			ends[i-1] = prevCut
+		}
+		if nextCut > starts[i+1] {
+			starts[i+1] = nextCut
+		}
+	}
+
+	for i, t := range tokens {
+		if t.tag {
+			continue
+		}
+		if starts[i] >= ends[i] {
+			t.text = ""
+		} else {
+			t.text = t.text[starts[i]:ends[i]]
+		}
+	}
+	for i := 1; i < len(tokens); i += 2 {
+		if tokens[i].stripBefore {
+			tokens[i-1].text = strings.TrimRight(tokens[i-1].text, " \t\r\n")
+		}
+		if tokens[i].stripAfter {
+			tokens[i+1].text = strings.TrimLeft(tokens[i+1].text, " \t\r\n")
+		}
+	}
+}
+
+// hbNode is a node of a parsed Handlebars template.
+type hbNode struct {
+	text string // text to output as is
+	path string // expression to output
+
+	// block of path, with a helper ("if", "unless", "each" or "with") or
+	// without as a section
+	block         bool
+	helper        string
+	body, inverse []*hbNode
+
+	line int
+}
+
+var blockHelpers = map[string]bool{
+	"if":     true,
+	"unless": true,
+	"each":   true,
+	"with":   true,
+}
+
+// parseHandlebars parses tokens from *pos until {{/closing}}, or the end if
+// closing is empty.
+func parseHandlebars(tokens []*hbToken, pos *int, closing string) ([]*hbNode, error) {
+	nodes := []*hbNode{}
+	for ; *pos < len(tokens); *pos++ {
+		t := tokens[*pos]
+		if !t.tag {
+			if t.text != "" {
+				nodes = append(nodes, &hbNode{text: t.text})
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(t.text, "!"):
+			// comment
+		case t.text == "else" || t.text == "^":
+			if closing == "" {
+				return nil, fmt.Errorf("line %d: unexpected {{else}}", t.line)
+			}
+			return nodes, nil
+		case strings.HasPrefix(t.text, "/"):
+			name := strings.TrimSpace(t.text[1:])
+			if name != closing {
+				return nil, fmt.Errorf("line %d: unexpected {{/%s}}", t.line, name)
+			}
+			return nodes, nil
+		case strings.HasPrefix(t.text, "#") || strings.HasPrefix(t.text, "^"):
+			node, err := parseBlock(tokens, pos)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		case strings.HasPrefix(t.text, ">"):
+			return nil, fmt.Errorf("line %d: partials are not supported", t.line)
+		default:
+			fields := strings.Fields(t.text)
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("line %d: helper %q is not supported", t.line, fields[0])
+			}
+			nodes = append(nodes, &hbNode{path: fields[0], line: t.line})
+		}
+	}
+	if closing != "" {
+		return nil, fmt.Errorf("unclosed block {{#%s}}", closing)
+	}
+	return nodes, nil
+}
+
+// parseBlock parses the block beginning at tokens[*pos], leaving *pos at its end.
+func parseBlock(tokens []*hbToken, pos *int) (*hbNode, error) {
+	t := tokens[*pos]
+	fields := strings.Fields(t.text[1:])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("line %d: empty block", t.line)
+	}
+
+	node := &hbNode{block: true, line: t.line}
+	closing := fields[0]
+	switch {
+	case blockHelpers[fields[0]]:
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: {{#%s}} takes one path", t.line, fields[0])
+		}
+		node.helper, node.path = fields[0], fields[1]
+	case len(fields) == 1:
+		// section of the value, rendered by the blockHelperMissing rule
+		node.path = fields[0]
+	default:
+		return nil, fmt.Errorf("line %d: helper %q is not supported", t.line, fields[0])
+	}
+	inverted := strings.HasPrefix(t.text, "^")
+
+	*pos++
+	body, err := parseHandlebars(tokens, pos, closing)
+	if err != nil {
+		return nil, err
+	}
+	if *pos >= len(tokens) {
+		return nil, fmt.Errorf("line %d: unclosed block {{#%s}}", t.line, closing)
+	}
+	if end := tokens[*pos]; end.text == "else" || end.text == "^" {
+		*pos++
+		if node.inverse, err = parseHandlebars(tokens, pos, closing); err != nil {
+			return nil, err
+		}
+	}
+	node.body = body
+	if inverted {
+		node.body, node.inverse = node.inverse, node.body
+	}
+	return node, nil
+}
+
+// hbFrame is a context of evaluation.
+type hbFrame struct {
+	value interface{}
+	data  map[string]interface{} // @index, @key, @first and @last
+}
+
+// pushFrame returns frames with the context v. The data variables are
+// inherited from the current context unless data is given.
+func pushFrame(frames []hbFrame, v interface{}, data map[string]interface{}) []hbFrame {
+	if data == nil {
+		data = frames[len(frames)-1].data
+	}
+	return append(frames, hbFrame{value: v, data: data})
+}
+
+func lookupPath(frames []hbFrame, p string) (interface{}, error) {
+	frame := len(frames) - 1
+	for strings.HasPrefix(p, "../") {
+		p = p[3:]
+		if frame > 0 {
+			frame--
+		}
+	}
+	if p == "@root" || strings.HasPrefix(p, "@root.") || strings.HasPrefix(p, "@root/") {
+		frame, p = 0, "this"+p[len("@root"):]
+	} else if strings.HasPrefix(p, "@") {
+		return frames[frame].data[p[1:]], nil
+	}
+
+	v := frames[frame].value
+	if p == "this" || p == "." {
+		return v, nil
+	}
+	for _, prefix := range []string{"this.", "this/", "./"} {
+		p = strings.TrimPrefix(p, prefix)
+	}
+	for _, key := range strings.FieldsFunc(p, func(r rune) bool { return r == '.' || r == '/' }) {
+		switch o := v.(type) {
+		case map[string]interface{}:
+			v = o[key]
+		case map[string]string:
+			v = o[key]
+		case []interface{}:
+			if key == "length" {
+				v = float64(len(o))
+				continue
+			}
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(o) {
+				return nil, nil
+			}
+			v = o[i]
+		default:
+			return nil, nil
+		}
+	}
+	return v, nil
+}
+
+// truthy reports whether v is true in {{#if}} and {{#unless}}.
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case []interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// isEmpty reports whether v is empty in {{#with}}, where 0 is not.
+func isEmpty(v interface{}) bool {
+	if n, ok := v.(float64); ok {
+		return math.IsNaN(n)
+	}
+	return !truthy(v)
+}
+
+// stringify returns v as JavaScript's String() does.
+func stringify(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		if math.IsNaN(v) {
+			return "NaN"
+		}
+		if math.IsInf(v, 0) {
+			if v > 0 {
+				return "Infinity"
+			}
+			return "-Infinity"
+		}
+		return formatNumber(v)
+	case []interface{}:
+		s := make([]string, len(v))
+		for i, e := range v {
+			s[i] = stringify(e)
+		}
+		return strings.Join(s, ",")
+	case map[string]interface{}, map[string]string:
+		return "[object Object]"
+	}
+	return fmt.Sprint(v)
+}
+
+// formatNumber returns n as JavaScript's Number.prototype.toString() does.
+func formatNumber(n float64) string {
+	if n == 0 {
+		return "0" // including -0
+	}
+	if abs := math.Abs(n); abs >= 1e21 || abs < 1e-6 {
+		s := strconv.FormatFloat(n, 'e', -1, 64)
+		i := strings.IndexByte(s, 'e')
+		exp, _ := strconv.Atoi(s[i+1:])
+		if exp > 0 {
+			return s[:i] + "e+" + strconv.Itoa(exp)
+		}
+		return s[:i] + "e" + strconv.Itoa(exp)
+	}
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func renderNodes(b *strings.Builder, nodes []*hbNode, frames []hbFrame) error {
+	for _, node := range nodes {
+		if node.path == "" {
+			b.WriteString(node.text)
+			continue
+		}
+		v, err := lookupPath(frames, node.path)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", node.line, err)
+		}
+		if !node.block {
+			b.WriteString(stringify(v))
+			continue
+		}
+
+		switch node.helper {
+		case "if":
+			err = renderBranch(b, node, truthy(v), frames)
+		case "unless":
+			err = renderBranch(b, node, !truthy(v), frames)
+		case "with":
+			if !isEmpty(v) {
+				err = renderNodes(b, node.body, pushFrame(frames, v, nil))
+			} else {
+				err = renderNodes(b, node.inverse, frames)
+			}
+		case "each":
+			err = renderEach(b, node, v, frames)
+		default:
+			// blockHelperMissing: values other than true, false, null and
+			// arrays, including 0 and "", are the context of the body
+			switch v := v.(type) {
+			case []interface{}:
+				err = renderEach(b, node, v, frames)
+			case bool:
+				err = renderBranch(b, node, v, frames)
+			case nil:
+				err = renderNodes(b, node.inverse, frames)
+			default:
+				err = renderNodes(b, node.body, pushFrame(frames, v, nil))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderBranch(b *strings.Builder, node *hbNode, cond bool, frames []hbFrame) error {
+	if cond {
+		return renderNodes(b, node.body, frames)
+	}
+	return renderNodes(b, node.inverse, frames)
+}
+
+func renderEach(b *strings.Builder, node *hbNode, v interface{}, frames []hbFrame) error {
+	switch v := v.(type) {
+	case []interface{}:
+		if len(v) == 0 {
+			break
+		}
+		for i, e := range v {
+			data := map[string]interface{}{"key": float64(i), "index": float64(i), "first": i == 0, "last": i == len(v)-1}
+			if err := renderNodes(b, node.body, pushFrame(frames, e, data)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		if len(v) == 0 {
+			break
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			data := map[string]interface{}{"key": k, "index": float64(i), "first": i == 0, "last": i == len(keys)-1}
+			if err := renderNodes(b, node.body, pushFrame(frames, v[k], data)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return renderNodes(b, node.inverse, frames)
+}
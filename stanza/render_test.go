@@ -0,0 +1,112 @@
+package stanza
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+type m = map[string]interface{}
+
+func TestRenderHandlebars(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		data interface{}
+		want string
+	}{
+		{"paths", "SELECT * LIMIT {{limit}}", m{"limit": 10.0}, "SELECT * LIMIT 10"},
+		{"nested paths", "{{a.b}} {{a/b}} {{this.a.b}} {{./a.b}}", m{"a": m{"b": "x"}}, "x x x x"},
+		{"missing paths", "[{{a}}{{a.b}}{{c.0}}]", m{"c": nil}, "[]"},
+		{"no escaping", "{{s}}", m{"s": "<a & 'b'>"}, "<a & 'b'>"},
+		{"triple and ampersand", "{{{s}}}{{& s}}", m{"s": "<"}, "<<"},
+		{"array elements and length", "{{a.1}} {{a.length}}", m{"a": []interface{}{"x", "y"}}, "y 2"},
+		{
+			"numbers",
+			"{{a}} {{b}} {{c}} {{d}} {{e}} {{f}}",
+			m{"a": 1.5, "b": 1e21, "c": 1e-7, "d": math.Copysign(0, -1), "e": 0.000001, "f": 1e20},
+			"1.5 1e+21 1e-7 0 0.000001 100000000000000000000",
+		},
+		{"arrays and objects", "{{a}}|{{o}}", m{"a": []interface{}{1.0, "x", nil, true}, "o": m{}}, "1,x,,true|[object Object]"},
+		{
+			"if",
+			"{{#if a}}y{{else}}n{{/if}}{{#if b}}y{{else}}n{{/if}}{{#if c}}y{{else}}n{{/if}}{{#if d}}y{{else}}n{{/if}}{{#if e}}y{{else}}n{{/if}}{{#if f}}y{{^}}n{{/if}}",
+			m{"a": 0.0, "b": "", "c": []interface{}{}, "d": m{}, "e": "0", "f": false},
+			"nnnyyn",
+		},
+		{"unless", "{{#unless a}}x{{/unless}}{{#unless b}}y{{else}}z{{/unless}}", m{"a": false, "b": true}, "xz"},
+		{
+			"each",
+			"{{#each a}}{{@index}}:{{this}}{{#if @first}}F{{/if}}{{#if @last}}L{{/if}},{{/each}}",
+			m{"a": []interface{}{"x", "y", "z"}},
+			"0:xF,1:y,2:zL,",
+		},
+		{"each of objects", "{{#each o}}{{@key}}={{this}};{{/each}}", m{"o": m{"b": 2.0, "a": 1.0}}, "a=1;b=2;"},
+		{"each with else", "{{#each a}}x{{else}}none{{/each}}", m{"a": []interface{}{}}, "none"},
+		{
+			"parent contexts",
+			"{{#each items}}{{../prefix}}{{name}} {{/each}}",
+			m{"prefix": "ex:", "items": []interface{}{m{"name": "a"}, m{"name": "b"}}},
+			"ex:a ex:b ",
+		},
+		{"root", "{{#each a}}{{@root.p}}{{this}}{{/each}}", m{"p": "-", "a": []interface{}{1.0, 2.0}}, "-1-2"},
+		{"with", "{{#with a}}{{b}}{{/with}}{{#with z}}{{this}}{{/with}}{{#with e}}x{{else}}-{{/with}}", m{"a": m{"b": "x"}, "z": 0.0, "e": ""}, "x0-"},
+		{
+			"data in with",
+			"{{#each a}}{{#with this}}{{@index}}{{name}}{{/with}}{{/each}}",
+			m{"a": []interface{}{m{"name": "x"}, m{"name": "y"}}},
+			"0x1y",
+		},
+		{
+			"sections",
+			"{{#a}}[{{this}}]{{/a}}{{#b}}B{{/b}}{{#c}}[{{.}}]{{/c}}{{#d}}D{{/d}}{{^e}}E{{/e}}{{#f}}{{.}}{{/f}}",
+			m{"a": "x", "b": true, "c": 0.0, "d": nil, "e": []interface{}{}, "f": []interface{}{1.0, 2.0}},
+			"[x]B[0]E12",
+		},
+		{
+			"standalone lines",
+			"SELECT\n  {{#if a}}\n  ?s\n  {{/if}}\nWHERE",
+			m{"a": true},
+			"SELECT\n  ?s\nWHERE",
+		},
+		{"standalone else", "{{#if a}}\nx\n{{else}}\ny\n{{/if}}\n", m{"a": false}, "y\n"},
+		{"standalone comments", "a\n{{! comment }}\n{{!-- {{b}} --}}\nb", nil, "a\nb"},
+		{"not standalone", "a {{#if x}}b{{/if}}\nc", m{"x": true}, "a b\nc"},
+		{"whitespace control", "a  {{~b~}}  c\n{{~#if b}} d {{~/if}}", m{"b": "x"}, "axc d"},
+		{"escaped mustaches", "\\{{a}} {{a}}", m{"a": "x"}, "{{a}} x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderHandlebars(tt.text, tt.data)
+			if err != nil {
+				t.Fatalf("renderHandlebars(%q) error: %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("renderHandlebars(%q)\n got %q\nwant %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderHandlebarsErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"{{lookup a b}}", `helper "lookup" is not supported`},
+		{"{{#each a sep=\",\"}}{{/each}}", "{{#each}} takes one path"},
+		{"{{#list a}}{{/list}}", `helper "list" is not supported`},
+		{"a\n{{> partial}}", "line 2: partials are not supported"},
+		{"{{#each a}}x", "unclosed block {{#each}}"},
+		{"{{#if a}}x{{/each}}", "unexpected {{/each}}"},
+		{"{{else}}", "unexpected {{else}}"},
+		{"{{a", `unclosed "{{"`},
+	}
+
+	for _, tt := range tests {
+		if _, err := renderHandlebars(tt.text, nil); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("renderHandlebars(%q) = %v, want an error containing %q", tt.text, err, tt.err)
+		}
+	}
+}
@@ -1,14 +1,24 @@
 package stanza
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"io"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"mime"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"text/template"
+
+	"github.com/togostanza/ts/output"
 )
 
 //go:generate go-bindata -pkg=stanza data/...
@@ -18,13 +28,83 @@ type Stanza struct {
 	Name    string
 	Metadata
 	MetadataRaw interface{}
+
+	// Source is the source directory the stanza is found in, recorded as
+	// stanza:source in the generated metadata.
+	Source string
+
+	// Logger receives the build log of the stanza. The standard logger is used if nil.
+	Logger *log.Logger
+}
+
+// BuildOptions controls how stanzas are built.
+type BuildOptions struct {
+	Development bool
+
+	// InlineAssetsLimit is the maximum size in bytes of files in assets
+	// directory to be inlined into HTML templates as data URIs in production
+	// mode. Assets are not inlined if 0.
+	InlineAssetsLimit int64
+
+	// HtmlImport enables the output for HTML Imports (index.html) in addition
+	// to the ES module, and makes help pages use it.
+	HtmlImport bool
+
+	// HashAssets makes files in assets directory written with content-hashed
+	// names, and references to them in templates rewritten.
+	HashAssets bool
+
+	// Gzip makes gzip-compressed variants (".gz") of text files written next
+	// to them, which ts serve sends to the clients accepting them.
+	Gzip bool
+
+	// BaseURL is the URL where the stanzas are published. If set, help pages
+	// show how to load the stanza from there.
+	BaseURL string
+
+	// BasePath is the URL path under which the stanzas are served, used for
+	// the snippets in help pages unless BaseURL is set.
+	BasePath string
+
+	// AssetNames maps the provider's assets (e.g. "assets/css/ts.css") to the
+	// names to be referred in the generated files. See AssetName.
+	AssetNames map[string]string
+
+	// Output is the file system the outputs are written to. The local file
+	// system is used if nil.
+	Output output.FS
+
+	// SparqlProxy makes stanzas send queries through the proxy if set.
+	SparqlProxy *SparqlProxy
+
+	// FixturesURL makes stanzas take the responses to queries from the
+	// fixtures served under the URL, followed by the name of the stanza,
+	// instead of the endpoints. It is resolved against the URL of the
+	// stanza's module.
+	FixturesURL string
+
+	// BuildEventsURL is the URL of the server-sent events of builds. If set,
+	// help pages subscribe to it to reload after their stanza is rebuilt.
+	BuildEventsURL string
 }
 
-type Parameter struct {
-	Key         string      `json:"stanza:key"`
-	Description string      `json:"stanza:description"`
-	Example     interface{} `json:"stanza:example"`
-	Required    bool        `json:"stanza:required"`
+// SparqlProxy is the proxy stanzas send queries to the endpoints through.
+type SparqlProxy struct {
+	// URL is the URL of the proxy, resolved against the URL of the stanza's
+	// module. The endpoint is given as the endpoint parameter.
+	URL string `json:"url"`
+
+	// Endpoints are the endpoints to be queried through the proxy. "*" means
+	// all endpoints.
+	Endpoints []string `json:"endpoints"`
+}
+
+// OutputFS returns the file system the outputs are written to.
+func (opts BuildOptions) OutputFS() output.FS {
+	if opts.Output == nil {
+		return output.Disk{}
+	}
+	return opts.Output
 }
 
 type Metadata struct {
@@ -36,6 +116,7 @@ type Metadata struct {
 	Context    string      `json:"stanza:context"`
 	Display    string      `json:"stanza:display"`
 	License    string      `json:"stanza:license"`
+	Author     string      `json:"stanza:author"`
 }
 
 func (meta *Metadata) ParameterKeys() []string {
@@ -88,19 +169,27 @@ func NewStanza(baseDir, name string) (*Stanza, error) {
 	}
 	meta, err := LoadMetadata(st.MetadataPath())
 	if err != nil {
-		return nil, err
+		return nil, st.jsonError(st.MetadataPath(), err)
 	}
 	st.Metadata = *meta
 
 	metaRaw, err := LoadMetadataRaw(st.MetadataPath())
 	if err != nil {
-		return nil, err
+		return nil, st.jsonError(st.MetadataPath(), err)
 	}
 	st.MetadataRaw = metaRaw
 
 	return st, nil
 }
 
+func (st *Stanza) logf(format string, v ...interface{}) {
+	if st.Logger != nil {
+		st.Logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
 func (st *Stanza) MetadataPath() string {
 	return path.Join(st.BaseDir, "metadata.json")
 }
@@ -137,6 +226,10 @@ func (st *Stanza) DestIndexHtmlPath(destStanzaBase string) string {
 	return path.Join(destStanzaBase, "index.html")
 }
 
+func (st *Stanza) DestModulePath(destStanzaBase string) string {
+	return path.Join(destStanzaBase, st.ModuleName())
+}
+
 func (st *Stanza) DestHelpHtmlPath(destStanzaBase string) string {
 	return path.Join(destStanzaBase, "help.html")
 }
@@ -149,53 +242,155 @@ func (st *Stanza) ElementName() string {
 	return "togostanza-" + st.Name
 }
 
-func (st *Stanza) Build(destStanzaBase string, development bool) error {
-	if err := os.MkdirAll(destStanzaBase, os.FileMode(0755)); err != nil {
+// publicURL returns the URL or the path where the stanzas are published.
+func (opts BuildOptions) publicURL() string {
+	if opts.BaseURL != "" {
+		return opts.BaseURL
+	}
+	return opts.BasePath
+}
+
+// ModuleURL returns the URL of the ES module of the stanza published under
+// baseURL, which may be a URL or an absolute path, or an empty string if
+// baseURL is empty.
+func (st *Stanza) ModuleURL(baseURL string) string {
+	if baseURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + st.Name + "/" + st.ModuleName()
+}
+
+// ModuleName returns the filename of the ES module of the stanza.
+func (st *Stanza) ModuleName() string {
+	return st.Name + ".js"
+}
+
+// Fingerprint returns a digest of the stanza's build inputs: metadata.json,
+// index.js, templates, _header.html and assets. Fixtures are not build inputs,
+// so recording them does not change it.
+func (st *Stanza) Fingerprint() (string, error) {
+	paths := []string{st.MetadataPath(), st.IndexJsPath(), st.HeaderHtmlPath()}
+	templatePaths, err := filepath.Glob(st.TemplateGlobPattern())
+	if err != nil {
+		return "", err
+	}
+	paths = append(paths, templatePaths...)
+	if _, err := os.Stat(st.AssetsDir()); err == nil {
+		err := filepath.Walk(st.AssetsDir(), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsDir() {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	h := sha256.New()
+	for _, p := range paths {
+		rel, err := filepath.Rel(st.BaseDir, p)
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", rel, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (st *Stanza) Build(destStanzaBase string, opts BuildOptions) error {
+	return st.buildError(st.build(destStanzaBase, opts))
+}
+
+func (st *Stanza) build(destStanzaBase string, opts BuildOptions) error {
+	if err := st.Metadata.CheckParameters(); err != nil {
+		return &BuildError{Stanza: st.Name, File: st.MetadataPath(), Err: err}
+	}
+	for _, problem := range st.UsageProblems() {
+		st.logf("warning: %s: %s", st.MetadataPath(), problem)
+	}
+	out := opts.OutputFS()
+	if err := out.MkdirAll(destStanzaBase); err != nil {
 		return err
 	}
-	if err := st.buildIndexHtml(destStanzaBase, development); err != nil {
+	var assetNames map[string]string
+	if opts.HashAssets {
+		var err error
+		if assetNames, err = st.HashedAssetNames(); err != nil {
+			return err
+		}
+	}
+
+	if err := st.buildBundles(destStanzaBase, opts, assetNames); err != nil {
 		return err
 	}
-	if err := st.buildHelpHtml(destStanzaBase); err != nil {
+	if err := st.buildHelpHtml(destStanzaBase, opts); err != nil {
 		return err
 	}
-	if err := st.copyMetadataJson(destStanzaBase); err != nil {
+	if err := st.copyMetadataJson(out, destStanzaBase); err != nil {
 		return err
 	}
-	if err := st.copyAssets(destStanzaBase); err != nil {
+	if err := st.copyAssets(out, destStanzaBase, assetNames); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (st *Stanza) copyMetadataJson(destStanzaBase string) error {
+// SetSource records the source directory of the stanza in the metadata.
+func (st *Stanza) SetSource(source string) {
+	st.Source = source
+	if m, ok := st.MetadataRaw.(map[string]interface{}); ok {
+		m["stanza:source"] = source
+	}
+}
+
+func (st *Stanza) copyMetadataJson(out output.FS, destStanzaBase string) error {
 	destPath := st.DestMetadataPath(destStanzaBase)
 
-	if err := copyFile(destPath, st.MetadataPath()); err != nil {
-		return err
+	if st.Source == "" {
+		if err := copyFile(out, destPath, st.MetadataPath()); err != nil {
+			return err
+		}
+	} else {
+		var b bytes.Buffer
+		encoder := json.NewEncoder(&b)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(st.MetadataRaw); err != nil {
+			return err
+		}
+		if err := out.WriteFile(destPath, b.Bytes()); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("copied to %s", destPath)
+	st.logf("copied to %s", destPath)
 
 	return nil
 }
 
-func copyFile(dest, src string) error {
-	fin, err := os.Open(src)
+func copyFile(out output.FS, dest, src string) error {
+	data, err := ioutil.ReadFile(src)
 	if err != nil {
 		return err
 	}
-	defer fin.Close()
-	fout, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
-	defer fout.Close()
-	_, err = io.Copy(fout, fin)
-	return err
+	return out.WriteFile(dest, data)
 }
 
-func (st *Stanza) copyAssets(destStanzaBase string) error {
+// copyAssets copies the files in assets directory. Files in assetNames are
+// also copied to their hashed names, while the original names are kept for
+// the references which are not rewritten, such as url() in stylesheets.
+func (st *Stanza) copyAssets(out output.FS, destStanzaBase string, assetNames map[string]string) error {
 	if _, err := os.Stat(st.AssetsDir()); os.IsNotExist(err) {
 		return nil
 	}
@@ -209,15 +404,21 @@ func (st *Stanza) copyAssets(destStanzaBase string) error {
 		}
 		destPath := path.Join(st.DestAssetsDir(destStanzaBase), rel)
 		if info.Mode().IsDir() {
-			if err := os.MkdirAll(destPath, os.FileMode(0755)); err != nil {
+			if err := out.MkdirAll(destPath); err != nil {
 				return err
 			}
-			log.Printf("created directory %s", destPath)
-		} else {
-			if err := copyFile(destPath, srcPath); err != nil {
+			st.logf("created directory %s", destPath)
+			return nil
+		}
+		destPaths := []string{destPath}
+		if hashed, ok := assetNames[path.Join("assets", filepath.ToSlash(rel))]; ok {
+			destPaths = append(destPaths, path.Join(destStanzaBase, hashed))
+		}
+		for _, destPath := range destPaths {
+			if err := copyFile(out, destPath, srcPath); err != nil {
 				return err
 			}
-			log.Printf("copied to %s", destPath)
+			st.logf("copied to %s", destPath)
 		}
 		return nil
 	})
@@ -252,38 +453,168 @@ func (st *Stanza) headerHtml() ([]byte, error) {
 	return ioutil.ReadFile(path)
 }
 
-func (st *Stanza) buildIndexHtml(destStanzaBase string, development bool) error {
-	indexHtmlTmpl := MustTemplateAsset("data/index.html")
+// bundleSource is the contents of a stanza to be bundled into index.html and the ES module.
+type bundleSource struct {
+	IndexJs    string
+	Templates  map[string]string
+	HeaderHtml string
+}
 
+func (st *Stanza) loadBundleSource() (*bundleSource, error) {
 	indexJs, err := ioutil.ReadFile(st.IndexJsPath())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	templates, err := st.templates()
+	if err != nil {
+		return nil, err
+	}
+	for name, t := range templates {
+		if line, err := checkHandlebars(t); err != nil {
+			return nil, &BuildError{
+				Stanza: st.Name,
+				File:   path.Join(st.BaseDir, "templates", name),
+				Line:   line,
+				Err:    err,
+			}
+		}
+	}
+
+	headerHtml, err := st.headerHtml()
+	if err != nil {
+		return nil, err
+	}
+
+	return &bundleSource{
+		IndexJs:    string(indexJs),
+		Templates:  templates,
+		HeaderHtml: string(headerHtml),
+	}, nil
+}
+
+func (src *bundleSource) rewriteAssetReferences(assetNames map[string]string) *bundleSource {
+	templates := make(map[string]string)
+	for name, t := range src.Templates {
+		if isHtmlTemplate(name) {
+			t = rewriteAssetReferences(t, assetNames)
+		}
+		templates[name] = t
+	}
+	return &bundleSource{
+		IndexJs:    rewriteAssetReferences(src.IndexJs, assetNames),
+		Templates:  templates,
+		HeaderHtml: rewriteAssetReferences(src.HeaderHtml, assetNames),
+	}
+}
+
+func (st *Stanza) minifyBundleSource(src *bundleSource, opts BuildOptions) (*bundleSource, error) {
+	templates := make(map[string]string)
+	for name, t := range src.Templates {
+		if isHtmlTemplate(name) {
+			t = collapseHtml(t)
+			if opts.InlineAssetsLimit > 0 {
+				var err error
+				if t, err = st.inlineAssets(t, opts.InlineAssetsLimit); err != nil {
+					return nil, err
+				}
+			}
+		}
+		templates[name] = t
+	}
+
+	return &bundleSource{
+		IndexJs:    minifyJs(src.IndexJs),
+		Templates:  templates,
+		HeaderHtml: collapseHtml(src.HeaderHtml),
+	}, nil
+}
+
+// buildBundles generates the ES module of the stanza and, if opts.HtmlImport
+// is set, index.html to be loaded with HTML Imports. References to assets in
+// index.js, _header.html and HTML templates are rewritten according to
+// assetNames if given.
+func (st *Stanza) buildBundles(destStanzaBase string, opts BuildOptions, assetNames map[string]string) error {
+	src, err := st.loadBundleSource()
 	if err != nil {
 		return err
 	}
 
+	minified := src
+	if !opts.Development {
+		if minified, err = st.minifyBundleSource(src, opts); err != nil {
+			return err
+		}
+	}
+
+	if assetNames != nil {
+		src = src.rewriteAssetReferences(assetNames)
+		minified = minified.rewriteAssetReferences(assetNames)
+	}
+
+	type bundle struct {
+		destPath string
+		render   func(src *bundleSource, opts BuildOptions) ([]byte, error)
+	}
+	bundles := []bundle{{st.DestModulePath(destStanzaBase), st.renderModule}}
+	if opts.HtmlImport {
+		bundles = append(bundles, bundle{st.DestIndexHtmlPath(destStanzaBase), st.renderIndexHtml})
+	}
+
+	for _, b := range bundles {
+		output, err := b.render(minified, opts)
+		if err != nil {
+			return err
+		}
+
+		if !opts.Development {
+			original, err := b.render(src, opts)
+			if err != nil {
+				return err
+			}
+			st.logf("minified %s: %d -> %d bytes (%.1f%%)", b.destPath, len(original), len(output), float64(len(output))/float64(len(original))*100)
+		}
+
+		if err := opts.OutputFS().WriteFile(b.destPath, output); err != nil {
+			return err
+		}
+		st.logf("generated %s", b.destPath)
+	}
+
+	return nil
+}
+
+func (st *Stanza) descriptorJson(templates map[string]string, opts BuildOptions) (string, error) {
 	descriptor := struct {
-		Templates   map[string]string `json:"templates"`
-		Parameters  []string          `json:"parameters"`
-		ElementName string            `json:"elementName"`
-		Development bool              `json:"development"`
+		Templates   map[string]string     `json:"templates"`
+		Parameters  []descriptorParameter `json:"parameters"`
+		ElementName string                `json:"elementName"`
+		Development bool                  `json:"development"`
+		SparqlProxy *SparqlProxy          `json:"sparqlProxy,omitempty"`
+		FixturesURL string                `json:"fixturesUrl,omitempty"`
 	}{
 		Templates:   templates,
-		Parameters:  st.Metadata.ParameterKeys(),
+		Parameters:  st.Metadata.descriptorParameters(),
 		ElementName: st.ElementName(),
-		Development: development,
+		Development: opts.Development,
+		SparqlProxy: opts.SparqlProxy,
+	}
+	if opts.FixturesURL != "" {
+		descriptor.FixturesURL = strings.TrimSuffix(opts.FixturesURL, "/") + "/" + st.Name
 	}
 	descriptorJson, err := json.Marshal(descriptor)
 	if err != nil {
-		return err
+		return "", err
 	}
+	return string(descriptorJson), nil
+}
 
-	headerHtml, err := st.headerHtml()
+func (st *Stanza) renderIndexHtml(src *bundleSource, opts BuildOptions) ([]byte, error) {
+	indexHtmlTmpl := MustTemplateAsset("data/index.html")
+
+	descriptorJson, err := st.descriptorJson(src.Templates, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	b := struct {
@@ -292,25 +623,85 @@ func (st *Stanza) buildIndexHtml(destStanzaBase string, development bool) error
 		DescriptorJson string
 		HeaderHtml     string
 	}{
-		IndexJs:        string(indexJs),
-		DescriptorJson: string(descriptorJson),
-		HeaderHtml:     string(headerHtml),
+		StanzaJs:       "../" + opts.AssetName("assets/js/stanza.js"),
+		IndexJs:        src.IndexJs,
+		DescriptorJson: descriptorJson,
+		HeaderHtml:     src.HeaderHtml,
 	}
 
-	destPath := st.DestIndexHtmlPath(destStanzaBase)
-	w, err := os.Create(destPath)
+	var buf bytes.Buffer
+	if err := indexHtmlTmpl.Execute(&buf, b); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (st *Stanza) renderModule(src *bundleSource, opts BuildOptions) ([]byte, error) {
+	moduleTmpl := MustTemplateAsset("data/module.js")
+
+	descriptorJson, err := st.descriptorJson(src.Templates, opts)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	headerHtmlJson, err := json.Marshal(src.HeaderHtml)
+	if err != nil {
+		return nil, err
 	}
-	defer w.Close()
 
-	if err := indexHtmlTmpl.Execute(w, b); err != nil {
-		return err
+	b := struct {
+		StanzaJs       string
+		IndexJs        string
+		DescriptorJson string
+		HeaderHtmlJson string
+	}{
+		StanzaJs:       "../" + opts.AssetName("assets/js/stanza.esm.js"),
+		IndexJs:        src.IndexJs,
+		DescriptorJson: descriptorJson,
+		HeaderHtmlJson: string(headerHtmlJson),
 	}
 
-	log.Printf("generated %s", destPath)
+	var buf bytes.Buffer
+	if err := moduleTmpl.Execute(&buf, b); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-	return nil
+func isHtmlTemplate(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
+
+var assetReferencePattern = regexp.MustCompile("([\"'(`])assets/([^\"'()`\\s?#]+)")
+
+// inlineAssets replaces references to small files in assets directory with data URIs.
+func (st *Stanza) inlineAssets(html string, limit int64) (string, error) {
+	var err error
+	html = assetReferencePattern.ReplaceAllStringFunc(html, func(ref string) string {
+		m := assetReferencePattern.FindStringSubmatch(ref)
+		rel := path.Clean(m[2])
+		if strings.HasPrefix(rel, "../") {
+			return ref
+		}
+		assetPath := path.Join(st.AssetsDir(), rel)
+		info, statErr := os.Stat(assetPath)
+		if statErr != nil || !info.Mode().IsRegular() || info.Size() > limit {
+			return ref
+		}
+		data, readErr := ioutil.ReadFile(assetPath)
+		if readErr != nil {
+			err = readErr
+			return ref
+		}
+		mimeType := mime.TypeByExtension(path.Ext(rel))
+		if mimeType == "" {
+			mimeType = http.DetectContentType(data)
+		}
+		mimeType = strings.SplitN(mimeType, ";", 2)[0]
+		st.logf("inlined %s", assetPath)
+		return m[1] + "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	})
+	return html, err
 }
 
 func (st *Stanza) Tags() []string {
@@ -330,32 +721,45 @@ func (st *Stanza) Tags() []string {
 	return tags
 }
 
-func (st *Stanza) buildHelpHtml(destStanzaBase string) error {
+func (st *Stanza) buildHelpHtml(destStanzaBase string, opts BuildOptions) error {
 	tmpl := MustTemplateAsset("data/help.html")
 
 	destPath := st.DestHelpHtmlPath(destStanzaBase)
-	w, err := os.Create(destPath)
-	if err != nil {
-		return err
-	}
-	defer w.Close()
+
+	metadata := st.Metadata
+	metadata.Usage = st.Usage()
 
 	context := struct {
-		Name       string
-		Metadata   Metadata
-		Stylesheet string
-		Tags       []string
+		Name           string
+		Metadata       Metadata
+		Stylesheet     string
+		Tags           []string
+		Parameters     []helpParameter
+		ModuleName     string
+		ModuleURL      string
+		BuildEventsURL string
+		HtmlImport     bool
 	}{
-		Name:     st.Name,
-		Metadata: st.Metadata,
-		Tags:     st.Tags(),
+		Name:           st.Name,
+		Metadata:       metadata,
+		Parameters:     st.Metadata.helpParameters(),
+		Stylesheet:     "../" + opts.AssetName("assets/css/ts.css"),
+		Tags:           st.Tags(),
+		ModuleName:     st.ModuleName(),
+		ModuleURL:      st.ModuleURL(opts.publicURL()),
+		BuildEventsURL: opts.BuildEventsURL,
+		HtmlImport:     opts.HtmlImport,
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, context); err != nil {
+		return err
 	}
-
-	if err := tmpl.Execute(w, context); err != nil {
+	if err := opts.OutputFS().WriteFile(destPath, buf.Bytes()); err != nil {
 		return err
 	}
 
-	log.Printf("generated %s", destPath)
+	st.logf("generated %s", destPath)
 
 	return nil
 }
@@ -0,0 +1,292 @@
+package stanza
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// globalAttributes are attributes allowed on any element, which need not be
+// declared as parameters.
+var globalAttributes = map[string]bool{
+	"id":     true,
+	"class":  true,
+	"style":  true,
+	"slot":   true,
+	"hidden": true,
+	"title":  true,
+	"lang":   true,
+	"dir":    true,
+}
+
+var attributeEscaper = strings.NewReplacer("&", "&amp;", "\"", "&quot;", "<", "&lt;", ">", "&gt;")
+
+var usageAttributePattern = regexp.MustCompile(`([^\s"'=/>]+)(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?`)
+
+// GenerateUsage returns the usage snippet of the stanza, which is the
+// element with the examples of the parameters as attributes.
+func (st *Stanza) GenerateUsage() string {
+	var b strings.Builder
+	b.WriteString("<" + st.ElementName())
+	for _, p := range st.Metadata.Parameters {
+		if isBlank(p.Example) && !p.Required {
+			continue
+		}
+		fmt.Fprintf(&b, " %s=\"%s\"", p.Key, attributeEscaper.Replace(valueString(p.Example)))
+	}
+	b.WriteString("></" + st.ElementName() + ">")
+	return b.String()
+}
+
+// Usage returns stanza:usage of the metadata, or the generated one if it is empty.
+func (st *Stanza) Usage() string {
+	if strings.TrimSpace(st.Metadata.Usage) == "" {
+		return st.GenerateUsage()
+	}
+	return st.Metadata.Usage
+}
+
+// usageTagPattern returns the pattern of the start tag of the stanza element,
+// whose first group is the attributes.
+func usageTagPattern(elementName string) *regexp.Regexp {
+	return regexp.MustCompile(`<` + regexp.QuoteMeta(elementName) + `(\s[^>]*)?>`)
+}
+
+// usageAttributes returns the attributes of the stanza element in usage.
+// ok is false if the element is not found.
+func usageAttributes(usage, elementName string) (attrs []string, ok bool) {
+	m := usageTagPattern(elementName).FindStringSubmatch(usage)
+	if m == nil {
+		return nil, false
+	}
+	for _, attr := range usageAttributePattern.FindAllStringSubmatch(m[1], -1) {
+		attrs = append(attrs, strings.ToLower(attr[1]))
+	}
+	return attrs, true
+}
+
+// UsageProblems returns the differences between the hand-written usage and
+// the parameters: attributes not declared as parameters and required
+// parameters not given. It returns nil if the usage is generated.
+func (st *Stanza) UsageProblems() []string {
+	if strings.TrimSpace(st.Metadata.Usage) == "" {
+		return nil
+	}
+	attrs, ok := usageAttributes(st.Metadata.Usage, st.ElementName())
+	if !ok {
+		return []string{fmt.Sprintf("usage does not use <%s>", st.ElementName())}
+	}
+
+	declared := st.declaredAttributes()
+	given := make(map[string]bool)
+	problems := []string{}
+	for _, attr := range attrs {
+		given[attr] = true
+		if !declared(attr) {
+			problems = append(problems, fmt.Sprintf("usage has attribute %q which is not declared in stanza:parameter", attr))
+		}
+	}
+	for _, p := range st.Metadata.Parameters {
+		if p.Required && !given[strings.ToLower(p.Key)] {
+			problems = append(problems, fmt.Sprintf("usage lacks required parameter %q", p.Key))
+		}
+	}
+	return problems
+}
+
+// declaredAttributes returns a function reporting whether an attribute
+// (lowercased) may be given to the stanza element.
+func (st *Stanza) declaredAttributes() func(attr string) bool {
+	declared := make(map[string]bool)
+	for _, p := range st.Metadata.Parameters {
+		declared[strings.ToLower(p.Key)] = true
+	}
+	return func(attr string) bool {
+		return declared[attr] || globalAttributes[attr] || strings.HasPrefix(attr, "data-")
+	}
+}
+
+// fixUsageAttributes removes the attributes of the stanza element in usage
+// which are not declared as parameters, and adds the required parameters not
+// given with their examples. The rest of usage is kept as is. ok is false if
+// the element is not found.
+func (st *Stanza) fixUsageAttributes(usage string) (fixed string, ok bool) {
+	loc := usageTagPattern(st.ElementName()).FindStringSubmatchIndex(usage)
+	if loc == nil {
+		return "", false
+	}
+	attrsStart, attrsEnd := loc[2], loc[3]
+	if attrsStart < 0 {
+		// no attributes; add them before ">"
+		attrsStart, attrsEnd = loc[1]-1, loc[1]-1
+	}
+	attrs := usage[attrsStart:attrsEnd]
+
+	declared := st.declaredAttributes()
+	given := make(map[string]bool)
+	var b strings.Builder
+	b.WriteString(usage[:attrsStart])
+	pos := 0
+	for _, m := range usageAttributePattern.FindAllStringSubmatchIndex(attrs, -1) {
+		attr := strings.ToLower(attrs[m[2]:m[3]])
+		if declared(attr) {
+			given[attr] = true
+			b.WriteString(attrs[pos:m[1]])
+		} else {
+			// drop the attribute with the spaces before it
+			b.WriteString(strings.TrimRight(attrs[pos:m[0]], " \t\r\n"))
+		}
+		pos = m[1]
+	}
+	for _, p := range st.Metadata.Parameters {
+		if p.Required && !given[strings.ToLower(p.Key)] {
+			fmt.Fprintf(&b, " %s=\"%s\"", p.Key, attributeEscaper.Replace(valueString(p.Example)))
+		}
+	}
+	b.WriteString(attrs[pos:])
+	b.WriteString(usage[attrsEnd:])
+	return b.String(), true
+}
+
+// FixUsage rewrites stanza:usage in metadata.json with the generated one if
+// it is empty or missing. Otherwise, it fixes only the attributes of the stanza element:
+// those not declared as parameters are removed, and required parameters not
+// given are added with their examples. It reports whether the file is changed.
+func (st *Stanza) FixUsage() (bool, error) {
+	usage := st.Metadata.Usage
+	var fixed string
+	if strings.TrimSpace(usage) == "" {
+		fixed = st.GenerateUsage()
+	} else {
+		var ok bool
+		if fixed, ok = st.fixUsageAttributes(usage); !ok {
+			return false, &BuildError{Stanza: st.Name, File: st.MetadataPath(), Err: fmt.Errorf("usage does not use <%s>; fix it by hand, or empty it to generate", st.ElementName())}
+		}
+	}
+	if usage == fixed {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(st.MetadataPath())
+	if err != nil {
+		return false, err
+	}
+	start, end, err := findTopLevelValue(data, "stanza:usage")
+	if err != nil {
+		return false, st.jsonError(st.MetadataPath(), err)
+	}
+	prefix := ""
+	if start < 0 {
+		// add it after the last property
+		start = int64(len(bytes.TrimRight(data[:bytes.LastIndexByte(data, '}')], " \t\r\n")))
+		end = start
+		prefix = ",\n  \"stanza:usage\": "
+	}
+
+	var value bytes.Buffer
+	encoder := json.NewEncoder(&value)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(fixed); err != nil {
+		return false, err
+	}
+
+	rewritten := append([]byte{}, data[:start]...)
+	rewritten = append(rewritten, prefix...)
+	rewritten = append(rewritten, bytes.TrimRight(value.Bytes(), "\n")...)
+	rewritten = append(rewritten, data[end:]...)
+
+	info, err := os.Stat(st.MetadataPath())
+	if err != nil {
+		return false, err
+	}
+	if err := ioutil.WriteFile(st.MetadataPath(), rewritten, info.Mode()); err != nil {
+		return false, err
+	}
+	st.Metadata.Usage = fixed
+	return true, nil
+}
+
+// findTopLevelValue returns the range of the value of key in the JSON object
+// in data, keeping the rest of the file intact. start is -1 if key is not found.
+func findTopLevelValue(data []byte, key string) (start, end int64, err error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return -1, -1, err
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		return -1, -1, fmt.Errorf("not a JSON object")
+	}
+
+	// data is a valid object from here on
+	pos := skipJSONSpace(data, 0) + 1 // {
+	for {
+		pos = skipJSONSpace(data, pos)
+		if data[pos] == '}' {
+			return -1, -1, nil
+		}
+		keyEnd := skipJSONValue(data, pos)
+		var k string
+		if err := json.Unmarshal(data[pos:keyEnd], &k); err != nil {
+			return -1, -1, err
+		}
+		pos = skipJSONSpace(data, keyEnd) + 1 // :
+		pos = skipJSONSpace(data, pos)
+		valueEnd := skipJSONValue(data, pos)
+		if k == key {
+			return int64(pos), int64(valueEnd), nil
+		}
+		pos = skipJSONSpace(data, valueEnd)
+		if data[pos] == ',' {
+			pos++
+		}
+	}
+}
+
+func skipJSONSpace(data []byte, pos int) int {
+	for pos < len(data) && isSpace(data[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// skipJSONValue returns the end of the valid JSON value starting at pos.
+func skipJSONValue(data []byte, pos int) int {
+	depth := 0
+	for pos < len(data) {
+		c := data[pos]
+		switch c {
+		case '"':
+			pos++
+			for data[pos] != '"' {
+				if data[pos] == '\\' {
+					pos++
+				}
+				pos++
+			}
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				return pos
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				return pos
+			}
+		default:
+			if depth == 0 && isSpace(c) {
+				return pos
+			}
+		}
+		pos++
+		if depth == 0 && (c == '"' || c == '}' || c == ']') {
+			return pos
+		}
+	}
+	return pos
+}
@@ -0,0 +1,147 @@
+package stanza
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFixUsageAttributes(t *testing.T) {
+	st := &Stanza{
+		Name: "hello",
+		Metadata: Metadata{
+			Parameters: []Parameter{
+				{Key: "taxon", Example: "9606", Required: true},
+				{Key: "limit", Example: 10.0},
+				{Key: "title", Example: `"Hi" & <bye>`, Required: true},
+			},
+		},
+	}
+
+	tests := []struct {
+		usage string
+		fixed string
+	}{
+		{
+			`<togostanza-hello taxon="9606" title="x"></togostanza-hello>`,
+			`<togostanza-hello taxon="9606" title="x"></togostanza-hello>`,
+		},
+		{
+			// hand-written values, other attributes and the rest are kept
+			"<!-- human -->\n<togostanza-hello taxon=\"10090\" class=\"wide\" data-x='1' title=\"x\"></togostanza-hello>\n<p>note</p>",
+			"<!-- human -->\n<togostanza-hello taxon=\"10090\" class=\"wide\" data-x='1' title=\"x\"></togostanza-hello>\n<p>note</p>",
+		},
+		{
+			`<togostanza-hello taxon="9606" species="human" title="x" organism=mouse></togostanza-hello>`,
+			`<togostanza-hello taxon="9606" title="x"></togostanza-hello>`,
+		},
+		{
+			`<togostanza-hello limit="5"></togostanza-hello>`,
+			`<togostanza-hello limit="5" taxon="9606" title="&quot;Hi&quot; &amp; &lt;bye&gt;"></togostanza-hello>`,
+		},
+		{
+			`<togostanza-hello></togostanza-hello>`,
+			`<togostanza-hello taxon="9606" title="&quot;Hi&quot; &amp; &lt;bye&gt;"></togostanza-hello>`,
+		},
+		{
+			"<togostanza-hello\n  unknown\n  TAXON=\"1\"\n  title=\"x\"\n/>",
+			"<togostanza-hello\n  TAXON=\"1\"\n  title=\"x\"\n/>",
+		},
+	}
+
+	for _, tt := range tests {
+		fixed, ok := st.fixUsageAttributes(tt.usage)
+		if !ok {
+			t.Errorf("fixUsageAttributes(%q): element not found", tt.usage)
+			continue
+		}
+		if fixed != tt.fixed {
+			t.Errorf("fixUsageAttributes(%q)\n got %q\nwant %q", tt.usage, fixed, tt.fixed)
+		}
+	}
+
+	if _, ok := st.fixUsageAttributes(`<togostanza-other taxon="9606"></togostanza-other>`); ok {
+		t.Errorf("fixUsageAttributes found the element in the usage of another stanza")
+	}
+}
+
+func TestFindTopLevelValue(t *testing.T) {
+	tests := []struct {
+		data  string
+		value string
+	}{
+		{`{"stanza:usage": "<x></x>"}`, `"<x></x>"`},
+		{`{"a": {"stanza:usage": 1}, "stanza:usage" : "u\"}" , "b": 2}`, `"u\"}"`},
+		{"{\n  \"a\": [1, {\"b\": \"]\"}],\n  \"stanza:usage\": null\n}", `null`},
+		{`{"stanza:usage":{"x":[1,2]}}`, `{"x":[1,2]}`},
+		{`{"stanza:usage": 10}`, `10`},
+		{`{"a": 1}`, ``},
+		{`{}`, ``},
+	}
+
+	for _, tt := range tests {
+		start, end, err := findTopLevelValue([]byte(tt.data), "stanza:usage")
+		if err != nil {
+			t.Errorf("findTopLevelValue(%q): %v", tt.data, err)
+			continue
+		}
+		if tt.value == "" {
+			if start != -1 {
+				t.Errorf("findTopLevelValue(%q) = %d, %d; want not found", tt.data, start, end)
+			}
+			continue
+		}
+		if start < 0 || tt.data[start:end] != tt.value {
+			t.Errorf("findTopLevelValue(%q) = %d, %d; want the range of %s", tt.data, start, end, tt.value)
+		}
+	}
+
+	if _, _, err := findTopLevelValue([]byte(`[1]`), "stanza:usage"); err == nil {
+		t.Errorf("findTopLevelValue of an array: no error")
+	}
+}
+
+func TestFixUsageWithoutUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("testdata/nousage/metadata.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "metadata.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := NewStanza(dir, "nousage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed, err := st.FixUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fixed {
+		t.Fatal("FixUsage() did not change metadata.json")
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta map[string]interface{}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("metadata.json is broken: %v\n%s", err, data)
+	}
+	want := `<togostanza-nousage taxon="9606"></togostanza-nousage>`
+	if usage := meta["stanza:usage"]; usage != want {
+		t.Errorf("stanza:usage = %q, want %q", usage, want)
+	}
+	if meta["stanza:type"] != "Stanza" {
+		t.Errorf("stanza:type = %q, want it kept", meta["stanza:type"])
+	}
+}
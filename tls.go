@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	flagTLS     bool
+	flagTLSCert string
+	flagTLSKey  string
+)
+
+func addTLSFlags(cmd *Command) {
+	cmd.Flag.BoolVar(&flagTLS, "tls", false, "serve over HTTPS, with a self-signed certificate for localhost unless -tls-cert and -tls-key are given")
+	cmd.Flag.StringVar(&flagTLSCert, "tls-cert", "", "certificate file in PEM format (implies -tls)")
+	cmd.Flag.StringVar(&flagTLSKey, "tls-key", "", "private key file of the certificate in PEM format (implies -tls)")
+}
+
+// tlsEnabled reports whether the server is to serve over HTTPS.
+func tlsEnabled() bool {
+	return flagTLS || flagTLSCert != "" || flagTLSKey != ""
+}
+
+// tlsCertificateFiles returns the certificate and key files to serve with:
+// the given ones, or the cached self-signed certificate for localhost.
+func tlsCertificateFiles() (certFile, keyFile string, err error) {
+	if flagTLSCert != "" || flagTLSKey != "" {
+		if flagTLSCert == "" || flagTLSKey == "" {
+			return "", "", fmt.Errorf("-tls-cert and -tls-key must be given together")
+		}
+		return flagTLSCert, flagTLSKey, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", "", err
+	}
+	return localhostCertificate(filepath.Join(cacheDir, "togostanza-ts"))
+}
+
+// localhostCertificate returns the self-signed certificate for localhost in
+// dir, generating a new one if it does not exist or expires within a day.
+func localhostCertificate(dir string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, "localhost.crt")
+	keyFile = filepath.Join(dir, "localhost.key")
+
+	if pair, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if cert, err := x509.ParseCertificate(pair.Certificate[0]); err == nil && time.Now().Add(24*time.Hour).Before(cert.NotAfter) {
+			return certFile, keyFile, nil
+		}
+	}
+
+	certPEM, keyPEM, err := generateLocalhostCertificate()
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, os.FileMode(0600)); err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(certFile, certPEM, os.FileMode(0644)); err != nil {
+		return "", "", err
+	}
+	log.Printf("generated a self-signed certificate for localhost in %s", certFile)
+	log.Println("the browser will warn about it until the certificate is trusted")
+
+	return certFile, keyFile, nil
+}
+
+func generateLocalhostCertificate() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"ts development server"}, CommonName: "localhost"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// serverURLs returns the URLs of the stanzas served on the local host.
+func serverURLs(useTLS bool, port int, basePath string) []string {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	urls := []string{}
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		urls = append(urls, fmt.Sprintf("%s://%s:%d%s", scheme, host, port, basePath))
+	}
+	return urls
+}
@@ -0,0 +1,131 @@
+package watcher
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+type inotify struct {
+	fd    int
+	file  *os.File
+	mu    sync.Mutex
+	paths map[int]string // watch descriptor -> directory
+}
+
+func (w *Watcher) startInotify() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	in := &inotify{
+		fd:    fd,
+		file:  os.NewFile(uintptr(fd), "inotify"), // non-blocking, so reads go through the runtime poller
+		paths: make(map[int]string),
+	}
+	if err := in.addTree(w, w.root); err != nil {
+		in.file.Close()
+		return err
+	}
+	w.closer = in.file.Close
+
+	changed := make(chan struct{}, 1)
+	go w.debounce(changed)
+	go in.readEvents(w, changed)
+
+	return nil
+}
+
+func (in *inotify) addTree(w *Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if w.ignored(path) {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(in.fd, path, inotifyMask)
+		if err != nil {
+			return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+		}
+		in.mu.Lock()
+		in.paths[wd] = path
+		in.mu.Unlock()
+		return nil
+	})
+}
+
+func (in *inotify) readEvents(w *Watcher, changed chan<- struct{}) {
+	var buf [syscall.SizeofInotifyEvent * 4096]byte
+	for {
+		n, err := in.file.Read(buf[:])
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				if err != io.EOF {
+					log.Println("ERROR while watching files:", err)
+				}
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(ev.Len)]
+			offset += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			in.mu.Lock()
+			dir, ok := in.paths[int(ev.Wd)]
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(in.paths, int(ev.Wd))
+			}
+			in.mu.Unlock()
+			if !ok {
+				continue
+			}
+
+			path := dir
+			if name := cString(nameBytes); name != "" {
+				path = filepath.Join(dir, name)
+			}
+			if w.ignored(path) {
+				continue
+			}
+
+			if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if err := in.addTree(w, path); err != nil {
+					log.Println("ERROR while watching files:", err)
+				}
+			}
+
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
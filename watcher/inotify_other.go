@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package watcher
+
+import "errors"
+
+func (w *Watcher) startInotify() error {
+	return errors.New("not supported on this platform")
+}
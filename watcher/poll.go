@@ -0,0 +1,80 @@
+package watcher
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func (w *Watcher) snapshot() (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	err := filepath.Walk(w.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if w.ignored(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files[path] = fileState{info.ModTime(), info.Size()}
+		return nil
+	})
+	return files, err
+}
+
+func sameSnapshot(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, s := range a {
+		if t, ok := b[path]; !ok || !t.modTime.Equal(s.modTime) || t.size != s.size {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Watcher) startPolling(interval time.Duration) error {
+	prev, err := w.snapshot()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	w.closer = func() error {
+		ticker.Stop()
+		return nil
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				cur, err := w.snapshot()
+				if err != nil {
+					log.Println("ERROR while watching files:", err)
+					continue
+				}
+				if !sameSnapshot(prev, cur) {
+					prev = cur
+					w.notify()
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,101 @@
+// Package watcher notifies changes of files under a directory tree.
+//
+// It uses inotify where available and falls back to polling otherwise.
+package watcher
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const debounceDelay = 100 * time.Millisecond
+
+type Watcher struct {
+	root   string
+	ignore func(path string) bool
+	events chan struct{}
+	done   chan struct{}
+	closer func() error
+}
+
+// New starts watching root recursively, skipping the files and directories
+// for which ignore returns true. ignore may be nil. If poll is true, or inotify
+// is not available, it polls the tree every interval instead.
+func New(root string, ignore func(path string) bool, poll bool, interval time.Duration) (*Watcher, error) {
+	w := &Watcher{
+		root:   root,
+		ignore: ignore,
+		events: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	if !poll {
+		err := w.startInotify()
+		if err == nil {
+			return w, nil
+		}
+		log.Printf("inotify is not available (%s); falling back to polling", err)
+	}
+	if err := w.startPolling(interval); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Events returns a channel which receives a value after files are changed.
+// Bursts of changes are coalesced into a single notification.
+func (w *Watcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *Watcher) Close() error {
+	close(w.done)
+	return w.closer()
+}
+
+// IgnoreDirs returns a function for New which ignores dirs and everything
+// under them.
+func IgnoreDirs(dirs ...string) func(path string) bool {
+	cleaned := make([]string, len(dirs))
+	for i, dir := range dirs {
+		cleaned[i] = filepath.Clean(dir)
+	}
+	return func(path string) bool {
+		path = filepath.Clean(path)
+		for _, dir := range cleaned {
+			if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (w *Watcher) ignored(path string) bool {
+	return w.ignore != nil && w.ignore(path)
+}
+
+func (w *Watcher) notify() {
+	select {
+	case w.events <- struct{}{}:
+	default:
+	}
+}
+
+// debounce calls w.notify once changes stop arriving on changed for debounceDelay.
+func (w *Watcher) debounce(changed <-chan struct{}) {
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-changed:
+			timer = time.After(debounceDelay)
+		case <-timer:
+			timer = nil
+			w.notify()
+		case <-w.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIgnoreDirs(t *testing.T) {
+	ignore := IgnoreDirs("/base/dist/stanza", "/base/.cache/")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/base/dist/stanza", true},
+		{"/base/dist/stanza/hello/hello.js", true},
+		{"/base/.cache", true},
+		{"/base/.cache/a", true},
+		{"/base/dist", false},
+		{"/base/dist/stanza2", false},
+		{"/base/hello/index.js", false},
+	}
+	for _, tt := range tests {
+		if got := ignore(tt.path); got != tt.want {
+			t.Errorf("ignore(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWatcherIgnore(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "ts-watcher")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for _, sub := range []string{"hello", "node_modules"} {
+			if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ignore := func(path string) bool {
+			return filepath.Base(path) == "node_modules"
+		}
+		w, err := New(dir, ignore, poll, 20*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		write := func(name string) {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		notified := func() bool {
+			select {
+			case <-w.Events():
+				return true
+			case <-time.After(500 * time.Millisecond):
+				return false
+			}
+		}
+
+		write("node_modules/a.js")
+		if notified() {
+			t.Errorf("poll = %v: notified of a change in an ignored directory", poll)
+		}
+		write("hello/index.js")
+		if !notified() {
+			t.Errorf("poll = %v: not notified of a change", poll)
+		}
+		w.Close()
+	}
+}